@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+// artifactDownloads summarizes the result of downloading a set of artifacts
+type artifactDownloads struct {
+	sync.Mutex
+
+	Downloaded int
+	Skipped    int
+	Bytes      int64
+	Failures   map[string]error
+}
+
+func (d *artifactDownloads) record(dest string, n int64, skipped bool, err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	switch {
+	case err != nil:
+		d.Failures[dest] = err
+	case skipped:
+		d.Skipped++
+	default:
+		d.Downloaded++
+		d.Bytes += n
+	}
+}
+
+// filterArtifacts returns the artifacts matching any of the include globs (or all if there are
+// none) and none of the exclude globs
+// Globs are matched against both the full path and the file name of the artifact
+func filterArtifacts(artifacts []*circleci.Artifact, include, exclude []string) ([]*circleci.Artifact, error) {
+	filtered := []*circleci.Artifact{}
+	for _, artifact := range artifacts {
+		p := artifactPath(artifact)
+
+		included, err := matchesGlobs(p, include)
+		if err != nil {
+			return nil, err
+		}
+		excluded, err := matchesGlobs(p, exclude)
+		if err != nil {
+			return nil, err
+		}
+
+		if (len(include) == 0 || included) && !excluded {
+			filtered = append(filtered, artifact)
+		}
+	}
+
+	return filtered, nil
+}
+
+func matchesGlobs(p string, globs []string) (bool, error) {
+	for _, glob := range globs {
+		for _, candidate := range []string{p, path.Base(p)} {
+			matched, err := path.Match(glob, candidate)
+			if err != nil {
+				return false, fmt.Errorf("invalid glob %q: %s", glob, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// artifactPath returns the path of the artifact relative to the artifacts directory
+// The pretty path is prefixed by the environment variable of the artifacts directory (e.g.
+// $CIRCLE_ARTIFACTS/coverage/index.html) which is stripped
+func artifactPath(artifact *circleci.Artifact) string {
+	p := artifact.PrettyPath
+	if p == "" {
+		p = artifact.Path
+	}
+
+	if strings.HasPrefix(p, "$") {
+		if i := strings.Index(p, "/"); i != -1 {
+			p = p[i+1:]
+		}
+	}
+
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// downloadArtifacts downloads the artifacts into <dir>/<node index>/<path> using the given number
+// of concurrent downloads
+// Artifacts which already exist on disk with the expected size are skipped
+func downloadArtifacts(artifacts []*circleci.Artifact, dir string, concurrency int) *artifactDownloads {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		downloads = &artifactDownloads{Failures: map[string]error{}}
+		jobs      = make(chan *circleci.Artifact)
+		wg        sync.WaitGroup
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for artifact := range jobs {
+				// skip once interrupted rather than recording a failure for every remaining artifact
+				if Context.Err() != nil {
+					continue
+				}
+				dest := filepath.Join(dir, strconv.Itoa(artifact.NodeIndex), filepath.FromSlash(artifactPath(artifact)))
+				n, skipped, err := downloadArtifact(artifact, dest)
+				downloads.record(dest, n, skipped, err)
+			}
+		}()
+	}
+
+	for _, artifact := range artifacts {
+		jobs <- artifact
+	}
+	close(jobs)
+	wg.Wait()
+
+	return downloads
+}
+
+// downloadArtifact downloads the artifact to dest
+// The artifact is written to a temporary file first so that partially downloaded files are never
+// mistaken as complete
+// Returns the number of bytes written and whether the download was skipped as dest already existed
+// with the expected size
+func downloadArtifact(artifact *circleci.Artifact, dest string) (int64, bool, error) {
+	if info, err := os.Stat(dest); err == nil {
+		// if the size cannot be fetched, e.g. as the artifact is served from a URL only signed for
+		// GET requests, it is checked once downloading starts instead
+		size, err := Client.GetArtifactSizeContext(Context, artifact)
+		if err == nil && size >= 0 && info.Size() == size {
+			return 0, true, nil
+		}
+	}
+
+	body, size, err := Client.DownloadArtifactContext(Context, artifact)
+	if err != nil {
+		return 0, false, err
+	}
+	defer body.Close()
+
+	if info, err := os.Stat(dest); err == nil && size >= 0 && info.Size() == size {
+		return 0, true, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, false, err
+	}
+
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, false, err
+	}
+
+	n, err := io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("expected %d bytes but received %d", size, n)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, false, err
+	}
+
+	return n, false, os.Rename(tmp, dest)
+}
+
+// printArtifactDownloads prints a summary of the downloads to w, preceded by any failures to errs
+func printArtifactDownloads(w, errs io.Writer, downloads *artifactDownloads) {
+	for _, dest := range sortedErrorKeys(downloads.Failures) {
+		fmt.Fprintf(errs, "failed to download %s: %s\n", dest, contextErrorMessage(downloads.Failures[dest]))
+	}
+	fmt.Fprintf(w, "downloaded %d artifacts (%d bytes), skipped %d already downloaded, %d failed\n",
+		downloads.Downloaded, downloads.Bytes, downloads.Skipped, len(downloads.Failures))
+}
+
+func sortedErrorKeys(m map[string]error) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+func TestArtifactPath(t *testing.T) {
+	tests := []struct {
+		artifact *circleci.Artifact
+		want     string
+	}{
+		{&circleci.Artifact{PrettyPath: "$CIRCLE_ARTIFACTS/coverage/index.html", Path: "/tmp/circle-artifacts.abc/coverage/index.html"}, "coverage/index.html"},
+		{&circleci.Artifact{PrettyPath: "$CIRCLE_ARTIFACTS/report.xml"}, "report.xml"},
+		{&circleci.Artifact{Path: "/tmp/circle-artifacts.abc/report.xml"}, "tmp/circle-artifacts.abc/report.xml"},
+		{&circleci.Artifact{PrettyPath: "$CIRCLE_ARTIFACTS/../../etc/passwd"}, "etc/passwd"},
+	}
+
+	for _, test := range tests {
+		if got := artifactPath(test.artifact); got != test.want {
+			t.Errorf("artifactPath(%+v) returned %q, want %q", test.artifact, got, test.want)
+		}
+	}
+}
+
+func TestFilterArtifacts(t *testing.T) {
+	var (
+		index  = &circleci.Artifact{PrettyPath: "$CIRCLE_ARTIFACTS/coverage/index.html"}
+		style  = &circleci.Artifact{PrettyPath: "$CIRCLE_ARTIFACTS/coverage/style.css"}
+		report = &circleci.Artifact{PrettyPath: "$CIRCLE_ARTIFACTS/report.xml"}
+		all    = []*circleci.Artifact{index, style, report}
+	)
+
+	tests := []struct {
+		include, exclude []string
+		want             []*circleci.Artifact
+	}{
+		{nil, nil, all},
+		{[]string{"*.html"}, nil, []*circleci.Artifact{index}},
+		{[]string{"coverage/*"}, nil, []*circleci.Artifact{index, style}},
+		{[]string{"coverage/*"}, []string{"*.css"}, []*circleci.Artifact{index}},
+		{nil, []string{"coverage/*"}, []*circleci.Artifact{report}},
+		{[]string{"*.go"}, nil, []*circleci.Artifact{}},
+	}
+
+	for _, test := range tests {
+		got, err := filterArtifacts(all, test.include, test.exclude)
+		if err != nil {
+			t.Errorf("filterArtifacts(%v, %v) returned error: %v", test.include, test.exclude, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("filterArtifacts(%v, %v) returned %+v, want %+v", test.include, test.exclude, got, test.want)
+		}
+	}
+
+	if _, err := filterArtifacts(all, []string{"["}, nil); err == nil {
+		t.Errorf(`filterArtifacts(["["], []) returned no error for an invalid glob`)
+	}
+}
+
+func TestDownloadArtifacts(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = map[string]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/signed-for-get":
+			if r.Method != "GET" {
+				http.Error(w, "signature mismatch", http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, "signed")
+		default:
+			fmt.Fprintf(w, "contents of %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	Client = &circleci.Client{}
+
+	dir, err := ioutil.TempDir("", "circleci-cli-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifacts := []*circleci.Artifact{
+		{NodeIndex: 0, PrettyPath: "$CIRCLE_ARTIFACTS/coverage/index.html", URL: server.URL + "/new"},
+		{NodeIndex: 1, PrettyPath: "$CIRCLE_ARTIFACTS/report.xml", URL: server.URL + "/complete"},
+		{NodeIndex: 1, PrettyPath: "$CIRCLE_ARTIFACTS/partial.log", URL: server.URL + "/partial"},
+		{NodeIndex: 2, PrettyPath: "$CIRCLE_ARTIFACTS/signed.txt", URL: server.URL + "/signed-for-get"},
+		{NodeIndex: 0, PrettyPath: "$CIRCLE_ARTIFACTS/missing.txt", URL: server.URL + "/missing"},
+	}
+
+	// already downloaded with the expected size
+	writeTestFile(t, filepath.Join(dir, "1", "report.xml"), "contents of /complete")
+	writeTestFile(t, filepath.Join(dir, "2", "signed.txt"), "signed")
+	// interrupted download
+	writeTestFile(t, filepath.Join(dir, "1", "partial.log"), "contents")
+
+	downloads := downloadArtifacts(artifacts, dir, 2)
+
+	want := map[string]string{
+		filepath.Join("0", "coverage", "index.html"): "contents of /new",
+		filepath.Join("1", "report.xml"):             "contents of /complete",
+		filepath.Join("1", "partial.log"):            "contents of /partial",
+		filepath.Join("2", "signed.txt"):             "signed",
+	}
+	for name, contents := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("unable to read downloaded artifact %s: %v", name, err)
+			continue
+		}
+		if string(b) != contents {
+			t.Errorf("downloaded artifact %s contains %q, want %q", name, b, contents)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0", "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected missing artifact not to be written, stat returned %v", err)
+	}
+
+	if downloads.Downloaded != 2 || downloads.Skipped != 2 || len(downloads.Failures) != 1 {
+		t.Errorf("downloadArtifacts returned %d downloaded, %d skipped and failures %v, want 2, 2 and 1 failure",
+			downloads.Downloaded, downloads.Skipped, downloads.Failures)
+	}
+	if want := int64(len("contents of /new") + len("contents of /partial")); downloads.Bytes != want {
+		t.Errorf("downloadArtifacts returned %d bytes, want %d", downloads.Bytes, want)
+	}
+
+	if requests["GET /complete"] != 0 || requests["HEAD /complete"] != 1 {
+		t.Errorf("expected artifact with matching size to only be checked with HEAD, requests were %v", requests)
+	}
+	if requests["GET /new"] != 1 || requests["HEAD /new"] != 0 {
+		t.Errorf("expected artifact not on disk to be fetched without HEAD, requests were %v", requests)
+	}
+
+	var out, errs bytes.Buffer
+	printArtifactDownloads(&out, &errs, downloads)
+
+	wantOut := "downloaded 2 artifacts (36 bytes), skipped 2 already downloaded, 1 failed\n"
+	if out.String() != wantOut {
+		t.Errorf("printArtifactDownloads printed %q, want %q", out.String(), wantOut)
+	}
+	wantErrs := fmt.Sprintf("failed to download %s: 404: Not Found\n", filepath.Join(dir, "0", "missing.txt"))
+	if errs.String() != wantErrs {
+		t.Errorf("printArtifactDownloads printed errors %q, want %q", errs.String(), wantErrs)
+	}
+}
+
+func TestDownloadArtifacts_noPartialFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// claims more than is sent so that the download is cut short
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprint(w, "truncated")
+	}))
+	defer server.Close()
+
+	Client = &circleci.Client{}
+
+	dir, err := ioutil.TempDir("", "circleci-cli-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifacts := []*circleci.Artifact{{PrettyPath: "$CIRCLE_ARTIFACTS/big.bin", URL: server.URL + "/big"}}
+	downloads := downloadArtifacts(artifacts, dir, 1)
+
+	if len(downloads.Failures) != 1 {
+		t.Errorf("expected a failure for a truncated download, got %v", downloads.Failures)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "big.bin") {
+			t.Errorf("expected no file to be left for a truncated download, found %s", file.Name())
+		}
+	}
+}
+
+func TestDownloadArtifacts_interrupted(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, "contents")
+	}))
+	defer server.Close()
+
+	Client = &circleci.Client{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Context = ctx
+	defer func() { Context = context.Background() }()
+
+	dir, err := ioutil.TempDir("", "circleci-cli-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifacts := []*circleci.Artifact{
+		{PrettyPath: "$CIRCLE_ARTIFACTS/a.txt", URL: server.URL + "/a"},
+		{PrettyPath: "$CIRCLE_ARTIFACTS/b.txt", URL: server.URL + "/b"},
+	}
+	downloads := downloadArtifacts(artifacts, dir, 1)
+
+	if downloads.Downloaded != 0 || len(downloads.Failures) != 0 || requests != 0 {
+		t.Errorf("expected artifacts to be skipped once interrupted, got %d downloaded, failures %v and %d requests",
+			downloads.Downloaded, downloads.Failures, requests)
+	}
+}
+
+func writeTestFile(t *testing.T, path, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the configuration file holding named profiles, e.g.:
+//
+//	default-profile: public
+//	profiles:
+//	  public:
+//	    token-file: ~/.circleci-token
+//	  enterprise:
+//	    host: https://circleci.example.com
+//	    token-command: pass show circleci/enterprise
+//	    project: acme/widgets
+type config struct {
+	// DefaultProfile is the profile used if --profile is not given
+	DefaultProfile string              `yaml:"default-profile"`
+	Profiles       map[string]*profile `yaml:"profiles"`
+}
+
+// profile holds the settings for a CircleCI host
+type profile struct {
+	Host      string `yaml:"host"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token-file"`
+	// TokenCommand is run to retrieve the token, see --token-command
+	TokenCommand string `yaml:"token-command"`
+	// AuthMethod is how the token is sent, see --auth-method
+	AuthMethod string `yaml:"auth-method"`
+	// Project is used when the current project cannot be determined from the git remote
+	Project string `yaml:"project"`
+}
+
+// defaultConfigPath returns the location of the configuration file in the user's config directory
+func defaultConfigPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := homeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "circleci-cli", "config.yml"), nil
+}
+
+// loadConfig reads the configuration file at path
+// A missing file is treated as an empty configuration
+func loadConfig(path string) (*config, error) {
+	conf := &config{}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return conf, nil
+		}
+		return nil, fmt.Errorf("unable to read config file: %s", err)
+	}
+
+	if err := yaml.UnmarshalStrict(contents, conf); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %s", path, err)
+	}
+
+	return conf, nil
+}
+
+// Profile returns the profile with the given name, or the default profile if name is empty
+// Returns nil if no name is given and there is no default profile
+func (c *config) Profile(name string) (*profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok || p == nil {
+		names := make([]string, 0, len(c.Profiles))
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown profile %q: no profiles are configured", name)
+		}
+		return nil, fmt.Errorf("unknown profile %q, must be one of %s", name, strings.Join(names, ","))
+	}
+
+	return p, nil
+}
+
+// applyProfile sets the global flags that were not given on the command line or in the
+// environment from the profile
+func applyProfile(c *cli.Context, p *profile) error {
+	settings := map[string]string{"host": p.Host, "auth-method": p.AuthMethod}
+	if !c.IsSet("token") && !c.IsSet("token-file") && !c.IsSet("token-command") {
+		settings["token"] = p.Token
+		settings["token-file"] = expandHome(p.TokenFile)
+		settings["token-command"] = p.TokenCommand
+	}
+
+	for flag, value := range settings {
+		if value == "" || c.IsSet(flag) {
+			continue
+		}
+		if err := c.Set(flag, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expandHome replaces a leading ~ in path with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := homeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// exitInterrupted is the exit code used when interrupted, matching shells' 128 + SIGINT
+const exitInterrupted = 130
+
+// Context bounds all requests to CircleCI
+// It is canceled when the user interrupts the command or once --timeout elapses
+var Context = context.Background()
+
+// cancelOnInterrupt returns a context which is canceled on the first interrupt so that in-flight
+// requests are aborted and partial results can be printed
+// A second interrupt exits immediately
+func cancelOnInterrupt(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		cancel()
+
+		<-interrupts
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, cancel
+}
+
+// sleep waits for d, returning early with the context's error if it is canceled
+func sleep(d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-Context.Done():
+		return Context.Err()
+	}
+}
+
+// warnPartial handles an error fetching builds
+// If some builds were fetched before the error, a warning is printed and the caller may carry on
+// with them, otherwise the error is handled as usual
+func warnPartial(builds int, err error) {
+	if err == nil {
+		return
+	}
+
+	if builds == 0 {
+		handleClientError(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "warning: only %d builds were fetched: %s\n", builds, contextErrorMessage(err))
+}
+
+// exitIfIncomplete is called once partial results have been printed to exit with a failure status
+// if they are incomplete, either because of err (as passed to warnPartial) or because the command
+// was interrupted or timed out while fetching
+func exitIfIncomplete(err error) {
+	if err == nil && Context.Err() == nil {
+		return
+	}
+
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "warning: %s, results are incomplete\n", contextErrorMessage(Context.Err()))
+	}
+
+	if Context.Err() == context.Canceled {
+		os.Exit(exitInterrupted)
+	}
+	os.Exit(1)
+}
+
+// contextErrorMessage describes err, in terms of the command if it is due to the context being
+// canceled
+func contextErrorMessage(err error) string {
+	switch Context.Err() {
+	case context.DeadlineExceeded:
+		return "timed out (see --timeout)"
+	case context.Canceled:
+		return "interrupted"
+	default:
+		return err.Error()
+	}
+}
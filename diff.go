@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+// buildDiff describes what changed between two builds
+type buildDiff struct {
+	From *buildDiffSide `json:"from"`
+	To   *buildDiffSide `json:"to"`
+
+	// Commits are the commits of the to build, and of the builds between the two on its branch, which
+	// are not part of the from build, oldest first
+	Commits    []*circleci.CommitDetails `json:"commits"`
+	Parameters []*parameterChange        `json:"parameters"`
+	// CircleYML is a unified diff of the configuration, empty if it did not change
+	CircleYML string        `json:"circle_yml"`
+	Steps     []*stepChange `json:"steps"`
+
+	NewlyFailingTests []string `json:"newly_failing_tests"`
+	FixedTests        []string `json:"fixed_tests"`
+}
+
+// buildDiffSide summarizes one of the builds being compared
+type buildDiffSide struct {
+	BuildNum    int    `json:"build_num"`
+	Branch      string `json:"branch"`
+	VcsRevision string `json:"vcs_revision"`
+	Status      string `json:"status"`
+	Nodes       int    `json:"nodes"`
+}
+
+// parameterChange is a build parameter which was added, removed or changed
+// From or To is nil if the parameter was not set in that build
+type parameterChange struct {
+	Name string  `json:"name"`
+	From *string `json:"from"`
+	To   *string `json:"to"`
+}
+
+// stepChange compares a step in both builds
+// The status is empty if the step did not run in that build
+type stepChange struct {
+	Name       string `json:"name"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	FromMillis int64  `json:"from_millis"`
+	ToMillis   int64  `json:"to_millis"`
+}
+
+// diffBuilds compares the builds, along with their test metadata
+// between are the builds on the branch of the to build between the two, whose commits are included
+// as they were pushed since the from build too
+func diffBuilds(from, to *circleci.Build, between []*circleci.Build, fromTests, toTests []*circleci.TestMetadata) *buildDiff {
+	diff := &buildDiff{
+		From:       diffSide(from),
+		To:         diffSide(to),
+		Commits:    []*circleci.CommitDetails{},
+		Parameters: diffParameters(from.BuildParameters, to.BuildParameters),
+		Steps:      diffSteps(from, to),
+	}
+
+	seen := map[string]bool{}
+	for _, commit := range from.AllCommitDetails {
+		seen[commit.Commit] = true
+	}
+	sorted := make([]*circleci.Build, len(between))
+	copy(sorted, between)
+	sort.Sort(buildsByBuildNum(sorted))
+	for _, build := range append(sorted, to) {
+		for _, commit := range build.AllCommitDetails {
+			if !seen[commit.Commit] {
+				seen[commit.Commit] = true
+				diff.Commits = append(diff.Commits, commit)
+			}
+		}
+	}
+
+	var fromYML, toYML string
+	if from.CircleYML != nil {
+		fromYML = from.CircleYML.String
+	}
+	if to.CircleYML != nil {
+		toYML = to.CircleYML.String
+	}
+	diff.CircleYML = unifiedDiff(fmt.Sprintf("#%d/circle.yml", from.BuildNum), fmt.Sprintf("#%d/circle.yml", to.BuildNum), fromYML, toYML)
+
+	diff.NewlyFailingTests, diff.FixedTests = diffTests(fromTests, toTests)
+
+	return diff
+}
+
+// buildsBetween lists the builds on the branch of the to build which are newer than the from build
+// and older than the to build
+func buildsBetween(account, repo string, from, to *circleci.Build) ([]*circleci.Build, error) {
+	const pageSize = 100
+
+	between := []*circleci.Build{}
+	if from.BuildNum >= to.BuildNum {
+		return between, nil
+	}
+
+	for offset := 0; ; offset += pageSize {
+		builds, err := listBuilds(account, repo, to.Branch, "", pageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, build := range builds {
+			if build.BuildNum <= from.BuildNum {
+				return between, nil
+			}
+			if build.BuildNum < to.BuildNum {
+				between = append(between, build)
+			}
+		}
+
+		if len(builds) < pageSize {
+			return between, nil
+		}
+	}
+}
+
+func diffSide(build *circleci.Build) *buildDiffSide {
+	return &buildDiffSide{
+		BuildNum:    build.BuildNum,
+		Branch:      build.Branch,
+		VcsRevision: build.VcsRevision,
+		Status:      build.Status,
+		Nodes:       buildNodes(build),
+	}
+}
+
+func diffParameters(from, to map[string]string) []*parameterChange {
+	names := map[string]string{}
+	for name := range from {
+		names[name] = ""
+	}
+	for name := range to {
+		names[name] = ""
+	}
+
+	changes := []*parameterChange{}
+	for _, name := range sortedKeys(names) {
+		fromValue, inFrom := from[name]
+		toValue, inTo := to[name]
+		if inFrom && inTo && fromValue == toValue {
+			continue
+		}
+
+		change := &parameterChange{Name: name}
+		if inFrom {
+			change.From = &fromValue
+		}
+		if inTo {
+			change.To = &toValue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// diffSteps compares the status and duration of each step, in the order the steps ran in the to
+// build followed by any steps which only ran in the from build
+func diffSteps(from, to *circleci.Build) []*stepChange {
+	var (
+		fromDurations = stepDurations(from)
+		toDurations   = stepDurations(to)
+		changes       = []*stepChange{}
+		byName        = map[string]*stepChange{}
+	)
+
+	for _, step := range to.Steps {
+		if byName[step.Name] != nil {
+			continue
+		}
+		change := &stepChange{Name: step.Name, ToStatus: stepStatus(to, step.Name), ToMillis: millis(toDurations[step.Name])}
+		byName[step.Name] = change
+		changes = append(changes, change)
+	}
+
+	for _, step := range from.Steps {
+		change := byName[step.Name]
+		if change == nil {
+			change = &stepChange{Name: step.Name}
+			byName[step.Name] = change
+			changes = append(changes, change)
+		}
+		change.FromStatus = stepStatus(from, step.Name)
+		change.FromMillis = millis(fromDurations[step.Name])
+	}
+
+	return changes
+}
+
+// stepStatus returns the status of the step with the given name
+// If the status differs between nodes, the first unsuccessful status is used
+func stepStatus(build *circleci.Build, name string) string {
+	status := ""
+	for _, step := range build.Steps {
+		if step.Name != name {
+			continue
+		}
+		for _, action := range step.Actions {
+			if status == "" || status == "success" {
+				status = action.Status
+			}
+		}
+	}
+	return status
+}
+
+// diffTests returns the tests which failed in to but not in from, and those which failed in from
+// but passed in to
+func diffTests(from, to []*circleci.TestMetadata) (newlyFailing, fixed []string) {
+	fromResults, toResults := testResults(from), testResults(to)
+
+	newlyFailing, fixed = []string{}, []string{}
+	for name, passed := range toResults {
+		fromPassed, ranBefore := fromResults[name]
+		if !passed && (!ranBefore || fromPassed) {
+			newlyFailing = append(newlyFailing, name)
+		}
+		if passed && ranBefore && !fromPassed {
+			fixed = append(fixed, name)
+		}
+	}
+	sort.Strings(newlyFailing)
+	sort.Strings(fixed)
+
+	return newlyFailing, fixed
+}
+
+// testResults maps the name of each test which passed or failed to whether it passed
+// If a test ran more than once, it is considered failed if any run failed
+func testResults(metadata []*circleci.TestMetadata) map[string]bool {
+	results := map[string]bool{}
+	for _, metadatum := range metadata {
+		var passed bool
+		switch metadatum.Result {
+		case "success":
+			passed = true
+		case "failure", "failed", "error":
+			passed = false
+		default:
+			continue
+		}
+
+		name := (&flakyTest{Classname: metadatum.Classname, File: metadatum.File, Name: metadatum.Name}).String()
+		if previous, ok := results[name]; !ok || previous {
+			results[name] = passed
+		}
+	}
+	return results
+}
+
+// unifiedDiff returns a unified diff of the lines of from and to with three lines of context
+// Returns an empty string if they are the same
+func unifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	a, b := splitLines(from), splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// edits holds each line prefixed by ' ', '-' or '+' along with its line number in a and b
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		// extend the hunk until there are more than twice the context of unchanged lines
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		end, unchanged := start, 0
+		for k := start; k < len(edits) && unchanged <= 2*context; k++ {
+			if edits[k].op == ' ' {
+				unchanged++
+			} else {
+				unchanged, end = 0, k+1
+			}
+		}
+		last := end + context
+		if last > len(edits) {
+			last = len(edits)
+		}
+
+		var fromLines, toLines int
+		for _, e := range edits[first:last] {
+			if e.op != '+' {
+				fromLines++
+			}
+			if e.op != '-' {
+				toLines++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(edits[first].i, fromLines), hunkRange(edits[first].j, toLines))
+		for _, e := range edits[first:last] {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.line)
+		}
+
+		start = last
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the start line (counting from 0) and number of lines of a hunk as in unified
+// diffs, which count from 1 and refer to the line before empty ranges
+func hunkRange(start, lines int) string {
+	if lines == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if lines == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, lines)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func printBuildDiff(w io.Writer, diff *buildDiff) {
+	for _, side := range []*buildDiffSide{diff.From, diff.To} {
+		fmt.Fprintf(w, "#%d  %s  %s  %s  %d nodes\n", side.BuildNum, side.Branch, shortRevision(side.VcsRevision),
+			statusSprintfFunc(side.Status)("%s", side.Status), side.Nodes)
+	}
+
+	fmt.Fprintf(w, "\nCommits (%s..%s)\n", shortRevision(diff.From.VcsRevision), shortRevision(diff.To.VcsRevision))
+	if len(diff.Commits) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, commit := range diff.Commits {
+		fmt.Fprintf(w, "  %s %s (%s)\n", shortRevision(commit.Commit), commit.Subject, commit.AuthorName)
+	}
+
+	fmt.Fprintln(w, "\nBuild Parameters")
+	if len(diff.Parameters) == 0 {
+		fmt.Fprintln(w, "  unchanged")
+	}
+	for _, change := range diff.Parameters {
+		switch {
+		case change.From == nil:
+			fmt.Fprintln(w, successSprintf("  + %s=%s", change.Name, *change.To))
+		case change.To == nil:
+			fmt.Fprintln(w, failureSprintf("  - %s=%s", change.Name, *change.From))
+		default:
+			fmt.Fprintf(w, "  ~ %s: %s -> %s\n", change.Name, *change.From, *change.To)
+		}
+	}
+
+	fmt.Fprintln(w, "\ncircle.yml")
+	if diff.CircleYML == "" {
+		fmt.Fprintln(w, "  unchanged")
+	}
+	for _, line := range splitLines(diff.CircleYML) {
+		switch {
+		case strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++"):
+			line = successSprintf("%s", line)
+		case strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---"):
+			line = failureSprintf("%s", line)
+		}
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+
+	fmt.Fprintln(w, "\nSteps")
+	// the columns are aligned before the statuses are colored, as tabwriter would count the color
+	// codes towards the width of their cells
+	rows := [][]string{{"Step", fmt.Sprintf("#%d", diff.From.BuildNum), fmt.Sprintf("#%d", diff.To.BuildNum), "Change"}}
+	statuses := [][]string{{"", "", "", ""}}
+	for _, step := range diff.Steps {
+		change := "n/a"
+		if step.FromStatus != "" && step.ToStatus != "" {
+			change = formatMillis(step.ToMillis - step.FromMillis).String()
+			if step.ToMillis >= step.FromMillis {
+				change = "+" + change
+			}
+		}
+
+		rows = append(rows, []string{step.Name, stepSummary(step.FromStatus, step.FromMillis), stepSummary(step.ToStatus, step.ToMillis), change})
+		statuses = append(statuses, []string{"", step.FromStatus, step.ToStatus, ""})
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for j, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	for i, row := range rows {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			if status := statuses[i][j]; status != "" {
+				cells[j] = statusSprintfFunc(status)("%s", cell)
+			} else {
+				cells[j] = cell
+			}
+			if j < len(row)-1 {
+				cells[j] += strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell))
+			}
+		}
+		fmt.Fprintf(w, "  %s\n", strings.Join(cells, "  "))
+	}
+
+	fmt.Fprintln(w, "\nNewly Failing Tests")
+	if len(diff.NewlyFailingTests) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, name := range diff.NewlyFailingTests {
+		fmt.Fprintln(w, failureSprintf("  %s", name))
+	}
+
+	if len(diff.FixedTests) > 0 {
+		fmt.Fprintln(w, "\nFixed Tests")
+		for _, name := range diff.FixedTests {
+			fmt.Fprintln(w, successSprintf("  %s", name))
+		}
+	}
+}
+
+func stepSummary(status string, ms int64) string {
+	if status == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%s (%s)", status, formatMillis(ms))
+}
+
+func shortRevision(revision string) string {
+	if len(revision) > 7 {
+		return revision[:7]
+	}
+	return revision
+}
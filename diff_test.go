@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/jszwedko/go-circleci"
+)
+
+func TestPrintBuildDiff_alignsColoredSteps(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	diff := &buildDiff{
+		From: &buildDiffSide{BuildNum: 1},
+		To:   &buildDiffSide{BuildNum: 2},
+		Steps: []*stepChange{
+			{Name: "checkout", FromStatus: "success", ToStatus: "success", FromMillis: 1000, ToMillis: 1000},
+			{Name: "new", ToStatus: "failed", ToMillis: 2000},
+			{Name: "removed", FromStatus: "success", FromMillis: 3000},
+		},
+	}
+
+	var buf bytes.Buffer
+	printBuildDiff(&buf, diff)
+
+	steps := strings.SplitN(buf.String(), "\nSteps\n", 2)[1]
+	lines := strings.Split(steps, "\n")[:4]
+
+	// the Change column starts at the same visible position on every line
+	want := -1
+	for _, line := range lines {
+		plain := ansiEscapeRegexp.ReplaceAllString(line, "")
+		col := strings.LastIndex(plain, "  ") + 2
+		if want == -1 {
+			want = col
+		}
+		if col != want {
+			t.Errorf("misaligned steps table:\n%s", ansiEscapeRegexp.ReplaceAllString(strings.Join(lines, "\n"), ""))
+			break
+		}
+	}
+}
+
+func TestDiffBuilds_commitsOfBuildsBetween(t *testing.T) {
+	commit := func(sha string) *circleci.CommitDetails {
+		return &circleci.CommitDetails{Commit: sha}
+	}
+	from := &circleci.Build{BuildNum: 1, AllCommitDetails: []*circleci.CommitDetails{commit("a")}}
+	between := []*circleci.Build{
+		{BuildNum: 3, AllCommitDetails: []*circleci.CommitDetails{commit("c")}},
+		{BuildNum: 2, AllCommitDetails: []*circleci.CommitDetails{commit("a"), commit("b")}},
+	}
+	to := &circleci.Build{BuildNum: 4, AllCommitDetails: []*circleci.CommitDetails{commit("c"), commit("d")}}
+
+	diff := diffBuilds(from, to, between, nil, nil)
+
+	got := []string{}
+	for _, commit := range diff.Commits {
+		got = append(got, commit.Commit)
+	}
+	if want := []string{"b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("diffBuilds returned commits %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+// envVarNameRegexp matches valid environment variable names
+var envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// dotenvVar is a variable read from a dotenv file
+type dotenvVar struct {
+	Name  string
+	Value string
+	// Line is the line the variable is defined on, for error messages
+	Line int
+}
+
+// parseDotenv parses environment variables in dotenv syntax, e.g.:
+//
+//	# comments and blank lines are ignored
+//	export PLAIN=value # an optional export and trailing comments are ignored
+//	SINGLE='taken literally'
+//	DOUBLE="supports \"escapes\" such as \n"
+//	MULTILINE="first line
+//	second line"
+//
+// Variables are not expanded
+// If a variable is defined more than once, the last definition is used
+func parseDotenv(r io.Reader, filename string) ([]*dotenvVar, error) {
+	var (
+		scanner = bufio.NewScanner(r)
+		vars    = []*dotenvVar{}
+		byName  = map[string]*dotenvVar{}
+		lineNum = 0
+	)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	// nextLine is used for values spanning multiple lines
+	nextLine := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		lineNum++
+		return strings.TrimSuffix(scanner.Text(), "\r"), true
+	}
+
+	for {
+		line, ok := nextLine()
+		if !ok {
+			break
+		}
+		start := lineNum
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected NAME=VALUE", filename, start)
+		}
+		name, raw := strings.TrimSpace(line[:i]), line[i+1:]
+		rest := strings.TrimLeft(raw, " \t")
+		if !envVarNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("%s:%d: invalid variable name %q", filename, start, name)
+		}
+
+		var value string
+		switch {
+		case strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, `'`):
+			quote := rest[0]
+			rest = rest[1:]
+
+			var b bytes.Buffer
+			for {
+				closed := false
+				for j := 0; j < len(rest) && !closed; j++ {
+					switch c := rest[j]; {
+					case c == quote:
+						rest, closed = rest[j+1:], true
+					case c == '\\' && quote == '"' && j+1 < len(rest):
+						j++
+						switch rest[j] {
+						case 'n':
+							b.WriteByte('\n')
+						case 'r':
+							b.WriteByte('\r')
+						case 't':
+							b.WriteByte('\t')
+						case '"', '\\', '$', '`':
+							b.WriteByte(rest[j])
+						default:
+							b.WriteByte('\\')
+							b.WriteByte(rest[j])
+						}
+					default:
+						b.WriteByte(c)
+					}
+				}
+				if closed {
+					break
+				}
+
+				// the value continues on the next line
+				next, ok := nextLine()
+				if !ok {
+					return nil, fmt.Errorf("%s:%d: unterminated %c quoted value", filename, start, quote)
+				}
+				b.WriteByte('\n')
+				rest = next
+			}
+
+			rest = strings.TrimSpace(rest)
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, fmt.Errorf("%s:%d: unexpected %q after quoted value", filename, lineNum, rest)
+			}
+			value = b.String()
+		default:
+			// as in shell, comments must be preceded by whitespace so that values may contain #,
+			// e.g. COLOR=#fff
+			if strings.HasPrefix(rest, "#") && len(rest) < len(raw) {
+				rest = ""
+			}
+			for i := 1; i < len(rest); i++ {
+				if rest[i] == '#' && (rest[i-1] == ' ' || rest[i-1] == '\t') {
+					rest = rest[:i]
+					break
+				}
+			}
+			value = strings.TrimSpace(rest)
+		}
+
+		if v, ok := byName[name]; ok {
+			v.Value, v.Line = value, start
+			continue
+		}
+		v := &dotenvVar{Name: name, Value: value, Line: start}
+		byName[name] = v
+		vars = append(vars, v)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", filename, err)
+	}
+
+	return vars, nil
+}
+
+// maskedSuffix returns the end of the value which CircleCI leaves visible when masking it, e.g.
+// 1234 for xxxx1234
+// Returns an empty string if none of the value is visible
+func maskedSuffix(masked string) string {
+	if !strings.HasPrefix(masked, "xxxx") {
+		return ""
+	}
+	return masked[len("xxxx"):]
+}
+
+// envChange is a change to the environment variables of a project planned by planEnvImport
+type envChange struct {
+	// Action is one of add, overwrite, delete or skip
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	// Reason explains why a variable is skipped
+	Reason string `json:"reason,omitempty"`
+
+	value string
+}
+
+// planEnvImport plans the changes needed to import the variables into a project with the given
+// existing variables
+// As existing values are masked they cannot be compared, so existing variables are only
+// overwritten if overwrite is true
+// If prune is true, existing variables which are not being imported are deleted
+func planEnvImport(vars []*dotenvVar, existing []circleci.EnvVar, overwrite, prune bool) []*envChange {
+	current := map[string]string{}
+	for _, envVar := range existing {
+		current[envVar.Name] = envVar.Value
+	}
+
+	var (
+		changes  = []*envChange{}
+		imported = map[string]bool{}
+	)
+	for _, v := range vars {
+		imported[v.Name] = true
+
+		masked, exists := current[v.Name]
+		switch {
+		case !exists:
+			changes = append(changes, &envChange{Action: "add", Name: v.Name, value: v.Value})
+		case overwrite:
+			changes = append(changes, &envChange{Action: "overwrite", Name: v.Name, value: v.Value})
+		default:
+			reason := "already set (use --overwrite to replace it)"
+			if suffix := maskedSuffix(masked); suffix != "" && !strings.HasSuffix(v.Value, suffix) {
+				reason = fmt.Sprintf("already set to a different value ending in %s (use --overwrite to replace it)", suffix)
+			}
+			changes = append(changes, &envChange{Action: "skip", Name: v.Name, Reason: reason})
+		}
+	}
+
+	if prune {
+		names := []string{}
+		for name := range current {
+			if !imported[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			changes = append(changes, &envChange{Action: "delete", Name: name})
+		}
+	}
+
+	return changes
+}
+
+// pendingEnvChanges returns the number of changes which are not skipped
+func pendingEnvChanges(changes []*envChange) int {
+	n := 0
+	for _, change := range changes {
+		if change.Action != "skip" {
+			n++
+		}
+	}
+	return n
+}
+
+// printEnvPlan prints the changes planned by planEnvImport followed by a summary of them
+func printEnvPlan(w io.Writer, changes []*envChange) {
+	counts := map[string]int{}
+	for _, change := range changes {
+		counts[change.Action]++
+
+		switch change.Action {
+		case "add":
+			fmt.Fprintln(w, successSprintf("+ %s", change.Name))
+		case "overwrite":
+			fmt.Fprintln(w, notestsSprintf("~ %s", change.Name))
+		case "delete":
+			fmt.Fprintln(w, failureSprintf("- %s", change.Name))
+		default:
+			fmt.Fprintf(w, "  %s: %s\n", change.Name, change.Reason)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d to add, %d to overwrite, %d to delete, %d skipped\n",
+		counts["add"], counts["overwrite"], counts["delete"], counts["skip"])
+}
+
+// applyEnvChanges makes the planned changes to the project, printing each change as it is made
+// Carries on after failures, which are printed to errs, returning the number of changes which
+// failed
+func applyEnvChanges(w, errs io.Writer, account, repo string, changes []*envChange) int {
+	failures := 0
+	for _, change := range changes {
+		var err error
+		switch change.Action {
+		case "add", "overwrite":
+			_, err = Client.AddEnvVarContext(Context, account, repo, change.Name, change.value)
+		case "delete":
+			err = Client.DeleteEnvVarContext(Context, account, repo, change.Name)
+		default:
+			continue
+		}
+
+		if err != nil {
+			if Context.Err() != nil {
+				handleClientError(err)
+			}
+			fmt.Fprintf(errs, "failed to %s %s: %s\n", change.Action, change.Name, err)
+			failures++
+			continue
+		}
+
+		switch change.Action {
+		case "add":
+			fmt.Fprintf(w, "added %s\n", change.Name)
+		case "overwrite":
+			fmt.Fprintf(w, "overwrote %s\n", change.Name)
+		case "delete":
+			fmt.Fprintf(w, "deleted %s\n", change.Name)
+		}
+	}
+	return failures
+}
+
+// projectEnvVars is the environment variables of a project found by auditEnvVars
+type projectEnvVars struct {
+	Account    string `json:"account"`
+	Repository string `json:"repository"`
+	// EnvVars maps the names of the variables to their masked values
+	EnvVars map[string]string `json:"env_vars"`
+}
+
+// auditEnvVars lists the environment variables of each of the projects whose names match pattern,
+// using the given number of concurrent requests
+// Results are in the order of projects, leaving out projects without matching variables
+// Projects whose variables could not be listed are reported on errs and counted in failures
+func auditEnvVars(projects []*circleci.Project, pattern *regexp.Regexp, concurrency int, errs io.Writer) (audited []*projectEnvVars, failures int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		results = make([]*projectEnvVars, len(projects))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if Context.Err() != nil {
+					// interrupted, skip the remaining projects
+					continue
+				}
+
+				project := projects[i]
+				envVars, err := Client.ListEnvVarsContext(Context, project.Username, project.Reponame)
+				if err != nil {
+					mu.Lock()
+					if Context.Err() == nil {
+						fmt.Fprintf(errs, "unable to list env vars of %s/%s: %s\n", project.Username, project.Reponame, err)
+						failures++
+					}
+					mu.Unlock()
+					continue
+				}
+
+				matched := map[string]string{}
+				for _, envVar := range envVars {
+					if pattern.MatchString(envVar.Name) {
+						matched[envVar.Name] = envVar.Value
+					}
+				}
+				if len(matched) > 0 {
+					results[i] = &projectEnvVars{Account: project.Username, Repository: project.Reponame, EnvVars: matched}
+				}
+			}
+		}()
+	}
+
+	for i := range projects {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	audited = []*projectEnvVars{}
+	for _, result := range results {
+		if result != nil {
+			audited = append(audited, result)
+		}
+	}
+	return audited, failures
+}
+
+// printEnvAudit prints a table of projects against the variables found by auditEnvVars, showing
+// the end of each value left visible by masking so that projects with the same value can be spotted
+// Values too short for any of them to be visible are shown as (hidden) and variables which are not
+// set as -
+func printEnvAudit(w io.Writer, audited []*projectEnvVars) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, project := range audited {
+		for name := range project.EnvVars {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(t, "Project")
+	for _, name := range names {
+		fmt.Fprintf(t, "\t%s", name)
+	}
+	fmt.Fprintln(t)
+
+	for _, project := range audited {
+		fmt.Fprintf(t, "%s/%s", project.Account, project.Repository)
+		for _, name := range names {
+			masked, ok := project.EnvVars[name]
+			var cell string
+			switch {
+			case !ok:
+				cell = "-"
+			case maskedSuffix(masked) == "":
+				// too short for CircleCI to leave any of it visible
+				cell = "(hidden)"
+			default:
+				cell = maskedSuffix(masked)
+			}
+			fmt.Fprintf(t, "\t%s", cell)
+		}
+		fmt.Fprintln(t)
+	}
+	t.Flush()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []*dotenvVar
+	}{
+		{
+			name:  "plain values",
+			input: "FOO=bar\nBAZ = qux \n",
+			want:  []*dotenvVar{{"FOO", "bar", 1}, {"BAZ", "qux", 2}},
+		},
+		{
+			name:  "comments, blank lines and export",
+			input: "# a comment\n\n   # indented comment\nexport FOO=bar # trailing comment\n",
+			want:  []*dotenvVar{{"FOO", "bar", 4}},
+		},
+		{
+			name:  "# inside values",
+			input: "URL=http://example.com/#anchor\nCOLOR=#fff\nQUOTED=\"a # b\" # comment\nSINGLE='#x'\nEMPTY= # comment\n",
+			want: []*dotenvVar{
+				{"URL", "http://example.com/#anchor", 1},
+				{"COLOR", "#fff", 2},
+				{"QUOTED", "a # b", 3},
+				{"SINGLE", "#x", 4},
+				{"EMPTY", "", 5},
+			},
+		},
+		{
+			name:  "empty values",
+			input: "EMPTY=\nQUOTED=\"\"\n",
+			want:  []*dotenvVar{{"EMPTY", "", 1}, {"QUOTED", "", 2}},
+		},
+		{
+			name:  "double quoted escapes",
+			input: `FOO="a\nb\tc \"d\" \\ \$e \q"` + "\n",
+			want:  []*dotenvVar{{"FOO", "a\nb\tc \"d\" \\ $e \\q", 1}},
+		},
+		{
+			name:  "single quoted values are literal",
+			input: `FOO='a\nb "c" $d'` + "\n",
+			want:  []*dotenvVar{{"FOO", `a\nb "c" $d`, 1}},
+		},
+		{
+			name:  "multiline values",
+			input: "KEY=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\nNEXT=1\n",
+			want: []*dotenvVar{
+				{"KEY", "-----BEGIN KEY-----\nabc\n-----END KEY-----", 1},
+				{"NEXT", "1", 4},
+			},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "FOO=bar\r\nMULTI=\"a\r\nb\"\r\nQUOTED='c'\r\n",
+			want: []*dotenvVar{
+				{"FOO", "bar", 1},
+				{"MULTI", "a\nb", 2},
+				{"QUOTED", "c", 4},
+			},
+		},
+		{
+			name:  "duplicate keys use the last definition",
+			input: "FOO=first\nBAR=1\nFOO=second\n",
+			want:  []*dotenvVar{{"FOO", "second", 3}, {"BAR", "1", 2}},
+		},
+		{
+			name:  "no trailing newline",
+			input: "FOO=bar",
+			want:  []*dotenvVar{{"FOO", "bar", 1}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseDotenv(strings.NewReader(test.input), ".env")
+		if err != nil {
+			t.Errorf("%s: parseDotenv returned error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseDotenv returned %s, want %s", test.name, formatDotenvVars(got), formatDotenvVars(test.want))
+		}
+	}
+}
+
+func TestParseDotenv_errors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"FOO\n", ".env:1: expected NAME=VALUE"},
+		{"# comment\n1FOO=bar\n", `.env:2: invalid variable name "1FOO"`},
+		{"FOO-BAR=baz\n", `.env:1: invalid variable name "FOO-BAR"`},
+		{"=bar\n", `.env:1: invalid variable name ""`},
+		{"FOO=\"bar\nbaz\n", `.env:1: unterminated " quoted value`},
+		{"FOO='bar\n", `.env:1: unterminated ' quoted value`},
+		{"FOO=\"bar\\\"\n", `.env:1: unterminated " quoted value`},
+		{"FOO=\"bar\" baz\n", `.env:1: unexpected "baz" after quoted value`},
+		{"FOO=\"a\nb\" c\n", `.env:2: unexpected "c" after quoted value`},
+	}
+
+	for _, test := range tests {
+		_, err := parseDotenv(strings.NewReader(test.input), ".env")
+		if err == nil {
+			t.Errorf("parseDotenv(%q) returned no error, want %q", test.input, test.want)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("parseDotenv(%q) returned error %q, want %q", test.input, err, test.want)
+		}
+	}
+}
+
+func formatDotenvVars(vars []*dotenvVar) string {
+	s := []string{}
+	for _, v := range vars {
+		s = append(s, strings.Replace(strings.Replace(
+			v.Name+"="+v.Value, "\n", `\n`, -1), "\t", `\t`, -1))
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+func TestPlanEnvImport(t *testing.T) {
+	vars := []*dotenvVar{
+		{Name: "NEW", Value: "new value"},
+		{Name: "SAME", Value: "ends in 1234"},
+		{Name: "CHANGED", Value: "ends in abcd"},
+		{Name: "SHORT", Value: "x"},
+	}
+	existing := []circleci.EnvVar{
+		{Name: "SAME", Value: "xxxx1234"},
+		{Name: "CHANGED", Value: "xxxx9999"},
+		{Name: "SHORT", Value: "xxxx"},
+		{Name: "UNUSED_B", Value: "xxxxbbbb"},
+		{Name: "UNUSED_A", Value: "xxxxaaaa"},
+	}
+
+	const (
+		alreadySet = "already set (use --overwrite to replace it)"
+		different  = "already set to a different value ending in 9999 (use --overwrite to replace it)"
+	)
+
+	tests := []struct {
+		name             string
+		overwrite, prune bool
+		want             []*envChange
+	}{
+		{
+			name: "only adds by default",
+			want: []*envChange{
+				{Action: "add", Name: "NEW", value: "new value"},
+				{Action: "skip", Name: "SAME", Reason: alreadySet},
+				{Action: "skip", Name: "CHANGED", Reason: different},
+				{Action: "skip", Name: "SHORT", Reason: alreadySet},
+			},
+		},
+		{
+			name:      "overwrite",
+			overwrite: true,
+			want: []*envChange{
+				{Action: "add", Name: "NEW", value: "new value"},
+				{Action: "overwrite", Name: "SAME", value: "ends in 1234"},
+				{Action: "overwrite", Name: "CHANGED", value: "ends in abcd"},
+				{Action: "overwrite", Name: "SHORT", value: "x"},
+			},
+		},
+		{
+			name:  "prune without overwrite",
+			prune: true,
+			want: []*envChange{
+				{Action: "add", Name: "NEW", value: "new value"},
+				{Action: "skip", Name: "SAME", Reason: alreadySet},
+				{Action: "skip", Name: "CHANGED", Reason: different},
+				{Action: "skip", Name: "SHORT", Reason: alreadySet},
+				{Action: "delete", Name: "UNUSED_A"},
+				{Action: "delete", Name: "UNUSED_B"},
+			},
+		},
+		{
+			name:      "prune with overwrite",
+			overwrite: true,
+			prune:     true,
+			want: []*envChange{
+				{Action: "add", Name: "NEW", value: "new value"},
+				{Action: "overwrite", Name: "SAME", value: "ends in 1234"},
+				{Action: "overwrite", Name: "CHANGED", value: "ends in abcd"},
+				{Action: "overwrite", Name: "SHORT", value: "x"},
+				{Action: "delete", Name: "UNUSED_A"},
+				{Action: "delete", Name: "UNUSED_B"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := planEnvImport(vars, existing, test.overwrite, test.prune)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: planEnvImport returned %s, want %s", test.name, formatEnvChanges(got), formatEnvChanges(test.want))
+		}
+	}
+}
+
+func formatEnvChanges(changes []*envChange) string {
+	s := []string{}
+	for _, change := range changes {
+		s = append(s, fmt.Sprintf("%+v", *change))
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+func TestPlanEnvImport_emptyFilePrunesEverything(t *testing.T) {
+	existing := []circleci.EnvVar{{Name: "FOO", Value: "xxxx1234"}}
+
+	got := planEnvImport([]*dotenvVar{}, existing, false, true)
+	want := []*envChange{{Action: "delete", Name: "FOO"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planEnvImport returned %+v, want %+v", got, want)
+	}
+
+	if n := pendingEnvChanges(got); n != 1 {
+		t.Errorf("pendingEnvChanges returned %d, want 1", n)
+	}
+}
+
+func TestPrintEnvAudit(t *testing.T) {
+	audited := []*projectEnvVars{
+		{Account: "jszwedko", Repository: "bar", EnvVars: map[string]string{"NPM_TOKEN": "xxxx1234", "SHORT": "xxxx"}},
+		{Account: "jszwedko", Repository: "foo", EnvVars: map[string]string{"NPM_TOKEN": "xxxxabcd"}},
+	}
+
+	var buf bytes.Buffer
+	printEnvAudit(&buf, audited)
+
+	want := "Project       NPM_TOKEN  SHORT\n" +
+		"jszwedko/bar  1234       (hidden)\n" +
+		"jszwedko/foo  abcd       -\n"
+	if buf.String() != want {
+		t.Errorf("printEnvAudit printed:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
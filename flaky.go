@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+// flakyTest describes a test which both passed and failed without an apparent reason
+type flakyTest struct {
+	Classname string `json:"classname"`
+	File      string `json:"file"`
+	Name      string `json:"name"`
+
+	Runs      int     `json:"runs"`
+	Failures  int     `json:"failures"`
+	FlakeRate float64 `json:"flake_rate"`
+
+	// SameRevision is set if the test both passed and failed on the same revision
+	SameRevision bool `json:"same_revision"`
+	// Flips is the number of times the test failed in a build while passing in the builds before
+	// and after it
+	Flips int `json:"flips"`
+
+	LastSeenBuildNum int        `json:"last_seen_build_num"`
+	LastSeenAt       *time.Time `json:"last_seen_at"`
+	ExampleBuildNums []int      `json:"example_build_nums"`
+}
+
+func (t *flakyTest) String() string {
+	name := t.Name
+	if t.Classname != "" {
+		name = fmt.Sprintf("%s.%s", t.Classname, t.Name)
+	} else if t.File != "" {
+		name = fmt.Sprintf("%s: %s", t.File, t.Name)
+	}
+	return name
+}
+
+// testRun is the result of a test in a given build
+type testRun struct {
+	build  *circleci.Build
+	passed bool
+}
+
+// buildTestMetadata fetches the test metadata for each of the builds using the given number of
+// concurrent requests
+// Builds for which test metadata could not be fetched are reported on errs and left out
+func buildTestMetadata(builds []*circleci.Build, concurrency int, errs io.Writer) map[*circleci.Build][]*circleci.TestMetadata {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		metadata = map[*circleci.Build][]*circleci.TestMetadata{}
+		jobs     = make(chan *circleci.Build)
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for build := range jobs {
+				if Context.Err() != nil {
+					// interrupted, skip the remaining builds
+					continue
+				}
+
+				m, err := listTestMetadata(build.Username, build.Reponame, build.BuildNum)
+
+				mu.Lock()
+				switch {
+				case err == nil:
+					metadata[build] = m
+				case Context.Err() == nil:
+					// builds interrupted while being fetched are left out silently
+					fmt.Fprintf(errs, "unable to fetch test metadata for build %d: %s\n", build.BuildNum, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, build := range builds {
+		jobs <- build
+	}
+	close(jobs)
+	wg.Wait()
+
+	return metadata
+}
+
+// findFlakyTests finds the tests which passed and failed on the same revision or which failed
+// in a single build while passing in the builds around it
+func findFlakyTests(metadata map[*circleci.Build][]*circleci.TestMetadata) []*flakyTest {
+	var (
+		tests = map[string]*flakyTest{}
+		runs  = map[string][]testRun{}
+	)
+
+	for build, testMetadata := range metadata {
+		for _, metadatum := range testMetadata {
+			var passed bool
+			switch metadatum.Result {
+			case "success":
+				passed = true
+			case "failure", "failed", "error":
+				passed = false
+			default:
+				continue
+			}
+
+			key := strings.Join([]string{metadatum.Classname, metadatum.File, metadatum.Name}, "\x00")
+			if _, ok := tests[key]; !ok {
+				tests[key] = &flakyTest{Classname: metadatum.Classname, File: metadatum.File, Name: metadatum.Name}
+			}
+			runs[key] = append(runs[key], testRun{build: build, passed: passed})
+		}
+	}
+
+	flaky := []*flakyTest{}
+	for key, test := range tests {
+		testRuns := runs[key]
+		sort.Sort(testRunsByBuildNum(testRuns))
+
+		flakyBuilds := map[int]bool{}
+
+		results := map[string]map[bool]bool{}
+		for _, run := range testRuns {
+			if results[run.build.VcsRevision] == nil {
+				results[run.build.VcsRevision] = map[bool]bool{}
+			}
+			results[run.build.VcsRevision][run.passed] = true
+		}
+		for _, run := range testRuns {
+			if !run.passed && run.build.VcsRevision != "" && results[run.build.VcsRevision][true] {
+				test.SameRevision = true
+				flakyBuilds[run.build.BuildNum] = true
+			}
+		}
+
+		for i := 1; i < len(testRuns)-1; i++ {
+			if !testRuns[i].passed && testRuns[i-1].passed && testRuns[i+1].passed {
+				test.Flips++
+				flakyBuilds[testRuns[i].build.BuildNum] = true
+			}
+		}
+
+		if len(flakyBuilds) == 0 {
+			continue
+		}
+
+		test.Runs = len(testRuns)
+		for _, run := range testRuns {
+			if run.passed {
+				continue
+			}
+
+			test.Failures++
+			if flakyBuilds[run.build.BuildNum] {
+				test.LastSeenBuildNum = run.build.BuildNum
+				test.LastSeenAt = run.build.StopTime
+				test.ExampleBuildNums = append(test.ExampleBuildNums, run.build.BuildNum)
+			}
+		}
+		test.FlakeRate = float64(test.Failures) / float64(test.Runs)
+
+		// keep the most recent examples
+		if len(test.ExampleBuildNums) > 3 {
+			test.ExampleBuildNums = test.ExampleBuildNums[len(test.ExampleBuildNums)-3:]
+		}
+
+		flaky = append(flaky, test)
+	}
+
+	sort.Sort(flakyTestsByRate(flaky))
+
+	return flaky
+}
+
+func printFlakyTests(w io.Writer, tests []*flakyTest) {
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(t, "Test\tFlake Rate\tFailed\tLast Seen\tExamples\n")
+	for _, test := range tests {
+		lastSeen := fmt.Sprintf("%d", test.LastSeenBuildNum)
+		if test.LastSeenAt != nil {
+			lastSeen = fmt.Sprintf("%d (%s)", test.LastSeenBuildNum, relativeTime(time.Since(*test.LastSeenAt)))
+		}
+
+		examples := make([]string, len(test.ExampleBuildNums))
+		for i, buildNum := range test.ExampleBuildNums {
+			examples[i] = fmt.Sprintf("%d", buildNum)
+		}
+
+		fmt.Fprintf(t, "%s\t%.0f%%\t%d/%d\t%s\t%s\n",
+			test, test.FlakeRate*100, test.Failures, test.Runs, lastSeen, strings.Join(examples, ","))
+	}
+	t.Flush()
+}
+
+type testRunsByBuildNum []testRun
+
+func (r testRunsByBuildNum) Len() int           { return len(r) }
+func (r testRunsByBuildNum) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r testRunsByBuildNum) Less(i, j int) bool { return r[i].build.BuildNum < r[j].build.BuildNum }
+
+type flakyTestsByRate []*flakyTest
+
+func (t flakyTestsByRate) Len() int      { return len(t) }
+func (t flakyTestsByRate) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t flakyTestsByRate) Less(i, j int) bool {
+	if t[i].FlakeRate != t[j].FlakeRate {
+		return t[i].FlakeRate > t[j].FlakeRate
+	}
+	return t[i].String() < t[j].String()
+}
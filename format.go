@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// formatFlag is shared by the commands that support printing with a Go template
+var formatFlag = cli.StringFlag{
+	Name:  "format",
+	Usage: "Print each result using a Go template (e.g. '{{.Username}}/{{.Reponame}}#{{.BuildNum}} {{color .Status}}'); see README for available functions",
+}
+
+var templateFuncs = template.FuncMap{
+	"color":    colorFunc,
+	"duration": durationFunc,
+	"elapsed":  elapsedFunc,
+	"ago":      agoFunc,
+	"json":     jsonFunc,
+}
+
+// printFormatted executes the template given by the --format flag for v, or each element of v if
+// it is a slice
+// Returns false, without printing anything, if --format was not given
+func printFormatted(c *cli.Context, v interface{}) bool {
+	format := c.String("format")
+	if format == "" {
+		return false
+	}
+
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to parse --format: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := executeTemplate(os.Stdout, tmpl, v); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to execute --format: %s\n", err)
+		os.Exit(1)
+	}
+
+	return true
+}
+
+func executeTemplate(w io.Writer, tmpl *template.Template, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		if err := tmpl.Execute(w, v); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(w)
+		return err
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		if err := tmpl.Execute(w, rv.Index(i).Interface()); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// colorFunc highlights the given values (or the status itself if no values are given) according
+// to the status
+func colorFunc(status string, a ...interface{}) string {
+	if len(a) == 0 {
+		return statusSprintfFunc(status)("%s", status)
+	}
+	return statusSprintfFunc(status)("%s", strings.TrimSuffix(fmt.Sprintln(a...), "\n"))
+}
+
+// durationFunc formats a duration
+// Integers are interpreted as milliseconds (e.g. BuildTimeMillis) and floats as seconds (e.g.
+// RunTime for test metadata)
+func durationFunc(v interface{}) (string, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "", nil
+		}
+		rv = rv.Elem()
+	}
+
+	if d, ok := rv.Interface().(time.Duration); ok {
+		return d.String(), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return (time.Duration(rv.Int()) * time.Millisecond).String(), nil
+	case reflect.Float32, reflect.Float64:
+		return time.Duration(rv.Float() * float64(time.Second)).String(), nil
+	default:
+		return "", fmt.Errorf("duration: unexpected type %T", v)
+	}
+}
+
+// elapsedFunc formats the duration between two times
+// If stop is not set, the duration until now is used
+func elapsedFunc(start, stop interface{}) (string, error) {
+	startTime, err := toTime(start)
+	if err != nil {
+		return "", err
+	}
+	if startTime.IsZero() {
+		return "", nil
+	}
+
+	stopTime, err := toTime(stop)
+	if err != nil {
+		return "", err
+	}
+	if stopTime.IsZero() {
+		return time.Since(startTime).Round(time.Second).String(), nil
+	}
+
+	return stopTime.Sub(startTime).String(), nil
+}
+
+// agoFunc formats a time relative to now (e.g. "3 hours ago")
+func agoFunc(v interface{}) (string, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return "", err
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+
+	return relativeTime(time.Since(t)), nil
+}
+
+func jsonFunc(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// toTime converts the different representations of time in the API structs into a time.Time
+// A zero time is returned for nil values
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, nil
+		}
+		return *t, nil
+	case string:
+		if t == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, t)
+	default:
+		return time.Time{}, fmt.Errorf("unexpected time type %T", v)
+	}
+}
+
+func relativeTime(d time.Duration) string {
+	suffix := "ago"
+	if d < 0 {
+		d = -d
+		suffix = "from now"
+	}
+
+	var (
+		n    int
+		unit string
+	)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	default:
+		n, unit = int(d/(24*time.Hour)), "day"
+	}
+
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s %s", n, unit, suffix)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+// logLine is a line of step output found by grepBuilds
+type logLine struct {
+	BuildNum int        `json:"build_num"`
+	Node     int        `json:"node"`
+	Step     int        `json:"step"`
+	StepName string     `json:"step_name"`
+	LineNum  int        `json:"line_num"`
+	Time     *time.Time `json:"time"`
+	Line     string     `json:"line"`
+	// Match is false for lines of context around matching lines
+	Match bool `json:"match"`
+}
+
+// follows reports whether l is the line after prev in the same output
+func (l *logLine) follows(prev *logLine) bool {
+	return prev != nil && l.BuildNum == prev.BuildNum && l.Node == prev.Node && l.Step == prev.Step &&
+		l.LineNum == prev.LineNum+1
+}
+
+// logMatcher collects the lines of an action's output which match a pattern, along with up to
+// context lines before and after each match
+// Only the lines that may be needed as context are held, however large the output is
+type logMatcher struct {
+	pattern *regexp.Regexp
+	context int
+
+	// before holds the most recent lines which have not been collected, up to context
+	before []*logLine
+	// after is the number of lines still to be collected after the last match
+	after int
+
+	lines []*logLine
+}
+
+func (m *logMatcher) add(line *logLine) {
+	switch {
+	case m.pattern.MatchString(line.Line):
+		line.Match = true
+		m.lines = append(m.lines, m.before...)
+		m.lines = append(m.lines, line)
+		m.before = nil
+		m.after = m.context
+	case m.after > 0:
+		m.lines = append(m.lines, line)
+		m.after--
+	case m.context > 0:
+		m.before = append(m.before, line)
+		if len(m.before) > m.context {
+			m.before = m.before[1:]
+		}
+	}
+}
+
+// buildLogMatches is the result of searching the output of a build, available once done is closed
+type buildLogMatches struct {
+	build *circleci.Build
+	done  chan struct{}
+	lines []*logLine
+	err   error
+}
+
+// grepBuilds searches the output of every step of the builds for lines matching pattern, using up
+// to concurrency builds at once
+// Output stored by sync --outputs is used for finished builds when available, otherwise it is
+// fetched
+// Results are returned in the order of builds, each becoming available as its build is searched
+func grepBuilds(builds []*circleci.Build, pattern *regexp.Regexp, context, concurrency int, store *buildStore) []*buildLogMatches {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*buildLogMatches, len(builds))
+	for i, build := range builds {
+		results[i] = &buildLogMatches{build: build, done: make(chan struct{})}
+	}
+
+	jobs := make(chan *buildLogMatches)
+	go func() {
+		for _, result := range results {
+			jobs <- result
+		}
+		close(jobs)
+	}()
+
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for result := range jobs {
+				if Context.Err() != nil {
+					// interrupted, skip the remaining builds
+					result.err = Context.Err()
+				} else {
+					result.lines, result.err = grepBuild(result.build, pattern, context, store)
+				}
+				close(result.done)
+			}
+		}()
+	}
+
+	return results
+}
+
+// grepBuild searches the output of every step of the build
+// Actions of non-parallel steps only run on the first node so are only searched there
+func grepBuild(summary *circleci.Build, pattern *regexp.Regexp, context int, store *buildStore) ([]*logLine, error) {
+	// builds which are listed do not include their steps
+	build, err := getBuild(summary.Username, summary.Reponame, summary.BuildNum)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []*logLine{}
+	for node := 0; node < buildNodes(build); node++ {
+		for _, step := range build.Steps {
+			action := stepAction(step, node)
+			if action == nil || !action.HasOutput || (node > 0 && !action.Parallel) {
+				continue
+			}
+
+			var (
+				matcher = &logMatcher{pattern: pattern, context: context}
+				lineNum = 0
+			)
+			splitter := &lineSplitter{fn: func(line string, t time.Time) {
+				lineNum++
+				l := &logLine{
+					BuildNum: build.BuildNum,
+					Node:     node,
+					Step:     action.Step,
+					StepName: step.Name,
+					LineNum:  lineNum,
+					Line:     line,
+				}
+				if !t.IsZero() {
+					l.Time = &t
+				}
+				matcher.add(l)
+			}}
+
+			err := grepActionOutputs(build, action, store, func(output *circleci.Output) error {
+				splitter.Write(output)
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("unable to search output of %s: %s", step.Name, err)
+			}
+			splitter.Flush()
+
+			lines = append(lines, matcher.lines...)
+		}
+	}
+
+	return lines, nil
+}
+
+// grepActionOutputs calls fn with each output of the action, preferring output in the store over
+// fetching it
+// Output of unfinished builds may be incomplete so is always fetched unless --offline was given
+func grepActionOutputs(build *circleci.Build, action *circleci.Action, store *buildStore, fn func(*circleci.Output) error) error {
+	if Store == nil && store != nil && buildFinished(build) &&
+		store.HasActionOutputs(build.Username, build.Reponame, build.BuildNum, action.Step, action.Index) {
+		return store.ActionOutputs(build.Username, build.Reponame, build.BuildNum, action.Step, action.Index, fn)
+	}
+
+	return streamActionOutputs(build, action, fn)
+}
+
+// logLinePrinter prints lines found by grepBuilds in the style of grep, separating lines of
+// context from matching lines with - rather than : and non-adjacent groups of lines with --
+type logLinePrinter struct {
+	w       io.Writer
+	context int
+
+	last *logLine
+}
+
+func (p *logLinePrinter) Print(line *logLine) {
+	if p.context > 0 && p.last != nil && !line.follows(p.last) {
+		fmt.Fprintln(p.w, "--")
+	}
+	p.last = line
+
+	sep := "-"
+	if line.Match {
+		sep = ":"
+	}
+
+	timestamp := ""
+	if line.Time != nil {
+		timestamp = " " + line.Time.UTC().Format(logTimeFormat)
+	}
+
+	fmt.Fprintf(p.w, "#%d [node %d: %s]%s%s %s\n", line.BuildNum, line.Node, line.StepName, timestamp, sep, line.Line)
+}
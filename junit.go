@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+
+	runTime float64
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr,omitempty"`
+	File      string        `xml:"file,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// writeJUnit writes the test metadata as JUnit XML
+// Tests are grouped into test suites by their class name, or file if they have no class name
+func writeJUnit(w io.Writer, metadata []*circleci.TestMetadata) error {
+	var (
+		suites = &junitTestSuites{}
+		byName = map[string]*junitTestSuite{}
+	)
+
+	for _, metadatum := range metadata {
+		name := metadatum.Classname
+		if name == "" {
+			name = metadatum.File
+		}
+		if name == "" {
+			name = "tests"
+		}
+
+		suite, ok := byName[name]
+		if !ok {
+			suite = &junitTestSuite{Name: name}
+			byName[name] = suite
+			suites.Suites = append(suites.Suites, suite)
+		}
+
+		testCase := &junitTestCase{
+			Name:      metadatum.Name,
+			Classname: metadatum.Classname,
+			File:      metadatum.File,
+			Time:      fmt.Sprintf("%.3f", metadatum.RunTime),
+		}
+
+		var message *junitMessage
+		if metadatum.Message != nil {
+			message = &junitMessage{
+				Message: strings.SplitN(strings.TrimSpace(*metadatum.Message), "\n", 2)[0],
+				Body:    *metadatum.Message,
+			}
+		} else {
+			message = &junitMessage{}
+		}
+
+		switch metadatum.Result {
+		case "failure", "failed":
+			testCase.Failure = message
+			suite.Failures++
+		case "error":
+			testCase.Error = message
+			suite.Errors++
+		case "skipped":
+			testCase.Skipped = &junitMessage{Message: message.Message}
+			suite.Skipped++
+		}
+
+		suite.Tests++
+		suite.runTime += metadatum.RunTime
+		suite.Time = fmt.Sprintf("%.3f", suite.runTime)
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w)
+	return err
+}
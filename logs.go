@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/jszwedko/go-circleci"
+)
+
+// logTailer prints the output of the actions of a build, only printing lines that have not been
+// printed before so that it can be called repeatedly as the build progresses
+type logTailer struct {
+	w io.Writer
+
+	// printed tracks the number of lines printed for each action, keyed by node and step
+	printed map[string]int
+	// done tracks the actions which finished and whose output has been completely printed
+	done map[string]bool
+}
+
+func newLogTailer(w io.Writer) *logTailer {
+	return &logTailer{w: w, printed: map[string]int{}, done: map[string]bool{}}
+}
+
+// Update prints any new output for the given build
+// If node is non-negative, only output for that node is printed
+func (l *logTailer) Update(build *circleci.Build, node int) error {
+	for i := 0; i < buildNodes(build); i++ {
+		if node >= 0 && i != node {
+			continue
+		}
+
+		for _, step := range build.Steps {
+			action := stepAction(step, i)
+			if action == nil || action.Status == "" || action.Status == "not_run" || (i > 0 && !action.Parallel) {
+				continue
+			}
+
+			key := fmt.Sprintf("%d/%d", i, action.Step)
+			if l.done[key] {
+				continue
+			}
+
+			finished := action.Status != "running"
+			if finished && !action.HasOutput {
+				l.done[key] = true
+				continue
+			}
+
+			prefix := fmt.Sprintf("[%s] ", step.Name)
+			if buildNodes(build) > 1 {
+				prefix = fmt.Sprintf("[node %d: %s] ", i, step.Name)
+			}
+
+			// CircleCI only returns the whole output of an action, so the output of running actions
+			// is fetched again on every update and the lines already printed skipped
+			lines := newLineWriter(l.w, prefix, l.printed[key])
+			err := streamActionOutputs(build, action, func(output *circleci.Output) error {
+				lines.WriteOutput(output)
+				return nil
+			})
+			// lines printed before an error are kept track of so they are not printed again
+			l.printed[key] = lines.Close(finished && err == nil)
+			if err != nil {
+				return err
+			}
+
+			l.done[key] = finished
+		}
+	}
+
+	return nil
+}
+
+// streamActionOutputs calls fn with each output of the given action of the build as it is
+// fetched, reading from the store if --offline was given
+// Output of running actions is not yet available at the action's output URL so it is fetched
+// from the build instead
+func streamActionOutputs(build *circleci.Build, action *circleci.Action, fn func(*circleci.Output) error) error {
+	if Store != nil {
+		return Store.ActionOutputs(build.Username, build.Reponame, build.BuildNum, action.Step, action.Index, fn)
+	}
+	if action.OutputURL != "" {
+		return Client.StreamActionOutputsContext(Context, action, fn)
+	}
+	return Client.StreamBuildActionOutputsContext(Context, build.Username, build.Reponame, build.BuildNum, action.Step, action.Index, fn)
+}
+
+// lineSplitter splits output into lines as it arrives, calling fn with each complete line and the
+// time of the output the line started in
+type lineSplitter struct {
+	fn func(line string, t time.Time)
+
+	// partial is the incomplete last line seen so far
+	partial     string
+	partialTime time.Time
+}
+
+// Write calls fn with the lines completed by the output
+func (s *lineSplitter) Write(output *circleci.Output) {
+	text, t := s.partial+output.Message, output.Time
+	if s.partial != "" {
+		t = s.partialTime
+	}
+
+	for {
+		i := strings.Index(text, "\n")
+		if i < 0 {
+			break
+		}
+		s.fn(strings.TrimSuffix(text[:i], "\r"), t)
+		text, t = text[i+1:], output.Time
+	}
+
+	s.partial, s.partialTime = text, t
+}
+
+// Flush calls fn with the incomplete last line, if any
+func (s *lineSplitter) Flush() {
+	if s.partial != "" {
+		s.fn(s.partial, s.partialTime)
+		s.partial = ""
+	}
+}
+
+// lineWriter prints output line by line as it arrives, after the first skip lines
+// Incomplete lines are held until they are completed or the writer is closed
+type lineWriter struct {
+	w      io.Writer
+	prefix string
+	skip   int
+
+	// lines is the number of complete lines seen, including those skipped
+	lines    int
+	splitter *lineSplitter
+}
+
+func newLineWriter(w io.Writer, prefix string, skip int) *lineWriter {
+	l := &lineWriter{w: w, prefix: prefix, skip: skip}
+	l.splitter = &lineSplitter{fn: l.printLine}
+	return l
+}
+
+// WriteOutput prints the lines completed by output
+func (l *lineWriter) WriteOutput(output *circleci.Output) {
+	l.splitter.Write(output)
+}
+
+// Close prints the incomplete last line if partial is true
+// Returns the total number of lines printed including those skipped
+func (l *lineWriter) Close(partial bool) int {
+	if partial {
+		l.splitter.Flush()
+	}
+
+	if l.lines < l.skip {
+		return l.skip
+	}
+	return l.lines
+}
+
+func (l *lineWriter) printLine(line string, _ time.Time) {
+	if l.lines >= l.skip {
+		fmt.Fprintf(l.w, "%s%s\n", l.prefix, line)
+	}
+	l.lines++
+}
+
+// tailLogs prints the output of the given build
+// If follow is true, new output is printed as it arrives until the build finishes
+func tailLogs(c *cli.Context, w io.Writer, account, repo string, buildNum, node int, follow bool) error {
+	tailer := newLogTailer(w)
+
+	for {
+		build, err := getBuild(account, repo, buildNum)
+		if err != nil {
+			return err
+		}
+
+		if err := tailer.Update(build, node); err != nil {
+			return err
+		}
+
+		if !follow || buildFinished(build) {
+			return nil
+		}
+
+		if err := sleep(c.Duration("interval")); err != nil {
+			return err
+		}
+	}
+}
+
+// logIndexEntry describes an action in the index written alongside downloaded logs
+type logIndexEntry struct {
+	Node           int    `json:"node"`
+	Step           int    `json:"step"`
+	Name           string `json:"name"`
+	Status         string `json:"status"`
+	ExitCode       *int   `json:"exit_code"`
+	DurationMillis int    `json:"duration_millis"`
+	// File is the path of the log relative to the download directory, empty if there is no output
+	File string `json:"file,omitempty"`
+}
+
+// logIndex is written to index.json alongside downloaded logs
+type logIndex struct {
+	Username string           `json:"username"`
+	Reponame string           `json:"reponame"`
+	BuildNum int              `json:"build_num"`
+	Status   string           `json:"status"`
+	Steps    []*logIndexEntry `json:"steps"`
+}
+
+// logDownloadOptions controls how downloaded logs are written
+type logDownloadOptions struct {
+	// Timestamps prefixes each line with the time the output was received
+	Timestamps bool
+	// StripANSI removes terminal escape sequences such as colors
+	StripANSI bool
+	// Concurrency is the maximum number of logs to download at once
+	Concurrency int
+}
+
+// logDownloads summarizes the result of downloading the logs of a build
+type logDownloads struct {
+	sync.Mutex
+
+	Downloaded int
+	Failures   map[string]error
+}
+
+func (d *logDownloads) record(dest string, err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if err != nil {
+		d.Failures[dest] = err
+		return
+	}
+	d.Downloaded++
+}
+
+// downloadLogs writes the output of each action of the given nodes of the build into
+// <dir>/node-<node>/<step>-<step name>.log, along with an index of the steps in <dir>/index.json
+// Actions of non-parallel steps only run on the first node so are only written there
+func downloadLogs(build *circleci.Build, dir string, nodes []int, opts logDownloadOptions) (*logDownloads, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+
+	var (
+		index     = &logIndex{Username: build.Username, Reponame: build.Reponame, BuildNum: build.BuildNum, Status: build.Status, Steps: []*logIndexEntry{}}
+		downloads = &logDownloads{Failures: map[string]error{}}
+		actions   = map[*logIndexEntry]*circleci.Action{}
+	)
+
+	for _, node := range nodes {
+		for _, step := range build.Steps {
+			action := stepAction(step, node)
+			if action == nil || (node > 0 && !action.Parallel) {
+				continue
+			}
+
+			entry := &logIndexEntry{
+				Node:           node,
+				Step:           action.Step,
+				Name:           step.Name,
+				Status:         action.Status,
+				ExitCode:       action.ExitCode,
+				DurationMillis: action.RunTimeMillis,
+			}
+			if action.HasOutput {
+				entry.File = fmt.Sprintf("node-%d/%02d-%s.log", node, action.Step, logFileName(step.Name))
+				actions[entry] = action
+			}
+			index.Steps = append(index.Steps, entry)
+		}
+	}
+
+	jobs := make(chan *logIndexEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range jobs {
+				dest := filepath.Join(dir, filepath.FromSlash(entry.File))
+				// skip once interrupted rather than recording a failure for every remaining log
+				if Context.Err() != nil {
+					continue
+				}
+				downloads.record(dest, downloadLog(build, actions[entry], dest, opts))
+			}
+		}()
+	}
+
+	for _, entry := range index.Steps {
+		if entry.File != "" {
+			jobs <- entry
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// the index only refers to logs which were written
+	for _, entry := range index.Steps {
+		if entry.File == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(entry.File))); err != nil {
+			entry.File = ""
+		}
+	}
+
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return downloads, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return downloads, err
+	}
+	return downloads, ioutil.WriteFile(filepath.Join(dir, "index.json"), append(b, '\n'), 0644)
+}
+
+// downloadLog writes the output of the action to dest
+// The log is written to a temporary file first so that partially downloaded logs are never
+// mistaken as complete
+func downloadLog(build *circleci.Build, action *circleci.Action, dest string, opts logDownloadOptions) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := newLogFileWriter(f, opts)
+	err = streamActionOutputs(build, action, func(output *circleci.Output) error {
+		return w.WriteOutput(output)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// logTimeFormat is the format of the times of output in downloaded and searched logs
+const logTimeFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	// ansiEscapeRegexp matches terminal control sequences (e.g. colors) and operating system
+	// commands (e.g. setting the window title)
+	ansiEscapeRegexp = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-_])`)
+	// logFileNameRegexp matches the characters replaced in log file names
+	logFileNameRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// logFileName converts a step name into a file name, e.g. "Run tests" into "run-tests"
+func logFileName(name string) string {
+	name = strings.Trim(logFileNameRegexp.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(name) > 64 {
+		name = strings.TrimRight(name[:64], "-")
+	}
+	if name == "" {
+		return "step"
+	}
+	return name
+}
+
+// logFileWriter writes the messages of outputs, optionally prefixing each line with the time of
+// the output it belongs to
+type logFileWriter struct {
+	w    *bufio.Writer
+	opts logDownloadOptions
+
+	// lineStart is true if the next message starts a new line
+	lineStart bool
+}
+
+func newLogFileWriter(w io.Writer, opts logDownloadOptions) *logFileWriter {
+	return &logFileWriter{w: bufio.NewWriter(w), opts: opts, lineStart: true}
+}
+
+// WriteOutput writes the message of the output
+func (l *logFileWriter) WriteOutput(output *circleci.Output) error {
+	message := strings.Replace(output.Message, "\r\n", "\n", -1)
+	if l.opts.StripANSI {
+		message = ansiEscapeRegexp.ReplaceAllString(message, "")
+	}
+
+	prefix := ""
+	if l.opts.Timestamps && !output.Time.IsZero() {
+		prefix = output.Time.UTC().Format(logTimeFormat) + " "
+	}
+
+	for message != "" {
+		if l.lineStart {
+			l.w.WriteString(prefix)
+		}
+
+		line := message
+		if i := strings.Index(message, "\n"); i >= 0 {
+			line = message[:i+1]
+		}
+		if _, err := l.w.WriteString(line); err != nil {
+			return err
+		}
+
+		l.lineStart = strings.HasSuffix(line, "\n")
+		message = message[len(line):]
+	}
+
+	return nil
+}
+
+// Flush writes any buffered output, terminating the last line if it is incomplete
+func (l *logFileWriter) Flush() error {
+	if !l.lineStart {
+		l.w.WriteString("\n")
+		l.lineStart = true
+	}
+	return l.w.Flush()
+}
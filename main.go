@@ -3,12 +3,17 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -17,6 +22,7 @@ import (
 
 	"github.com/codegangsta/cli"
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 )
 
 var (
@@ -77,6 +83,22 @@ func (f *Filter) String() string {
 	return string(*f)
 }
 
+var validAuthMethods = []string{"query", "header", "basic"}
+
+// parseAuthMethod parses the --auth-method value
+func parseAuthMethod(value string) (circleci.AuthMethod, error) {
+	switch value {
+	case "query":
+		return circleci.AuthQuery, nil
+	case "header":
+		return circleci.AuthHeader, nil
+	case "basic":
+		return circleci.AuthBasic, nil
+	default:
+		return 0, fmt.Errorf("unexpected --auth-method value: %q, must be one of %s", value, strings.Join(validAuthMethods, ","))
+	}
+}
+
 // Project is meant to be used as a cli.Generic to parse <account>/<repo> strings
 type Project struct {
 	Account    string
@@ -108,6 +130,14 @@ func (p *Project) String() string {
 func main() {
 	currentProject := getCurrentProject()
 
+	ctx, cancel := cancelOnInterrupt(context.Background())
+	defer cancel()
+	Context = ctx
+
+	// replaced if --timeout is given, so deferred via a closure to call whichever is set
+	cancelTimeout := func() {}
+	defer func() { cancelTimeout() }()
+
 	app := cli.NewApp()
 	app.Name = "circleci"
 	app.Usage = "Tool for interacting with the CircleCI API"
@@ -131,6 +161,29 @@ func main() {
 			Usage:  "Load API token from specified file",
 			EnvVar: "CIRCLE_TOKEN_FILE",
 		},
+		cli.StringFlag{
+			Name:   "token-command",
+			Value:  "",
+			Usage:  "Load API token from the output of specified command, such as a credential helper",
+			EnvVar: "CIRCLE_TOKEN_COMMAND",
+		},
+		cli.StringFlag{
+			Name:   "auth-method",
+			Value:  "header",
+			Usage:  fmt.Sprintf("How to send the API token to CircleCI; must be one of %s (query exposes the token in logs but is supported by older CircleCI Enterprise installs)", strings.Join(validAuthMethods, ",")),
+			EnvVar: "CIRCLE_AUTH_METHOD",
+		},
+		cli.IntFlag{
+			Name:   "max-attempts",
+			Value:  3,
+			Usage:  "Number of times to try requests which fail with network errors or server errors",
+			EnvVar: "CIRCLE_MAX_ATTEMPTS",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "Give up on the command after the specified duration (e.g. 30s, 5m); leave empty to wait indefinitely",
+			EnvVar: "CIRCLE_TIMEOUT",
+		},
 		cli.BoolFlag{
 			Name:   "debug, d",
 			Usage:  "Enable debug logging",
@@ -141,6 +194,26 @@ func main() {
 			Usage: "Suppress or forcely print highlighting, value can be: auto, always, never",
 			Value: "auto",
 		},
+		cli.StringFlag{
+			Name:   "output",
+			Usage:  fmt.Sprintf("Print machine readable output instead of human readable output; must be one of %s", strings.Join(validOutputFormats, ",")),
+			EnvVar: "CIRCLE_OUTPUT",
+		},
+		cli.BoolFlag{
+			Name:   "offline",
+			Usage:  "Read builds, test metadata and step output from the local store populated by sync rather than CircleCI (only supported by commands which read builds)",
+			EnvVar: "CIRCLE_OFFLINE",
+		},
+		cli.StringFlag{
+			Name:   "profile",
+			Usage:  "Use the host, token and project of the named profile from the config file",
+			EnvVar: "CIRCLE_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Load profiles from specified file rather than ~/.config/circleci-cli/config.yml",
+			EnvVar: "CIRCLE_CONFIG",
+		},
 	}
 	app.Before = func(c *cli.Context) (err error) {
 		if c.String("color") == "always" {
@@ -153,6 +226,41 @@ func main() {
 			)
 		}
 
+		if c.String("output") != "" && !isValidOutputFormat(c.String("output")) {
+			return fmt.Errorf(
+				"unexpected --output value: %q, must be one of %s", c.String("output"), strings.Join(validOutputFormats, ","),
+			)
+		}
+
+		configPath := c.String("config")
+		if configPath == "" {
+			if configPath, err = defaultConfigPath(); err != nil {
+				return fmt.Errorf("unable to locate config file: %s", err)
+			}
+		}
+
+		conf, err := loadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		profile, err := conf.Profile(c.String("profile"))
+		if err != nil {
+			return err
+		}
+
+		if profile != nil {
+			if err := applyProfile(c, profile); err != nil {
+				return err
+			}
+
+			if profile.Project != "" && currentProject.String() == "" {
+				if err := currentProject.Set(profile.Project); err != nil {
+					return fmt.Errorf("invalid project for profile: %s", err)
+				}
+			}
+		}
+
 		baseURL, err := url.Parse(c.String("host") + "/api/v1/")
 		if err != nil {
 			return err
@@ -167,11 +275,35 @@ func main() {
 
 			token = strings.TrimSpace(string(contents))
 		}
+		if token == "" && c.String("token-command") != "" {
+			token, err = runTokenCommand(c.String("token-command"))
+			if err != nil {
+				return err
+			}
+		}
+
+		if timeout := c.Duration("timeout"); timeout > 0 {
+			Context, cancelTimeout = context.WithTimeout(Context, timeout)
+		}
+
+		authMethod, err := parseAuthMethod(c.String("auth-method"))
+		if err != nil {
+			return err
+		}
 
 		Client = &circleci.Client{
-			Token:   token,
-			BaseURL: baseURL,
-			Debug:   c.Bool("debug"),
+			Token:       token,
+			AuthMethod:  authMethod,
+			BaseURL:     baseURL,
+			MaxAttempts: c.Int("max-attempts"),
+			Debug:       c.Bool("debug"),
+		}
+
+		if c.Bool("offline") {
+			Store, err = openBuildStore(baseURL)
+			if err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -192,22 +324,31 @@ func main() {
 					EnvVar: "CIRCLE_PROJECT",
 					Value:  &Project{},
 				},
+				formatFlag,
 			},
-			Action: func(c *cli.Context) {
-				projects, err := Client.ListProjects()
+			Action: online(func(c *cli.Context) {
+				projects, err := Client.ListProjectsContext(Context)
 				if err != nil {
 					handleClientError(err)
 				}
 
-				t := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
-				for _, project := range projects {
-					if c.IsSet("project") {
-						projectFlag := c.Generic("project").(*Project)
-						if project.Username != projectFlag.Account || project.Reponame != projectFlag.Repository {
-							continue
+				if c.IsSet("project") {
+					projectFlag := c.Generic("project").(*Project)
+					filtered := []*circleci.Project{}
+					for _, project := range projects {
+						if project.Username == projectFlag.Account && project.Reponame == projectFlag.Repository {
+							filtered = append(filtered, project)
 						}
 					}
+					projects = filtered
+				}
 
+				if printStructured(c, projects) {
+					return
+				}
+
+				t := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				for _, project := range projects {
 					if c.Bool("verbose") {
 						fmt.Fprintf(t, "%s/%s\f", project.Username, project.Reponame)
 					} else {
@@ -215,7 +356,7 @@ func main() {
 						if len(project.Branches[project.DefaultBranch].RecentBuilds) > 0 {
 							projectColorSprintf = statusSprintfFunc(project.Branches[project.DefaultBranch].RecentBuilds[0].Status)
 						}
-						fmt.Fprintf(t, projectColorSprintf("%s/%s\f", project.Username, project.Reponame))
+						fmt.Fprint(t, projectColorSprintf("%s/%s\f", project.Username, project.Reponame))
 					}
 
 					if !c.Bool("verbose") {
@@ -238,7 +379,7 @@ func main() {
 					}
 					fmt.Fprint(t, "\f")
 				}
-			},
+			}),
 		},
 		{
 			Name:    "recent-builds",
@@ -280,6 +421,7 @@ func main() {
 					Usage:  fmt.Sprintf("Show only builds with given status (cannot be used with --all); leave empty for all; must be one of %s", strings.Join(validFilters, ",")),
 					EnvVar: "CIRCLE_FILTER",
 				},
+				formatFlag,
 			},
 			Action: func(c *cli.Context) {
 				if c.Bool("all") {
@@ -296,10 +438,14 @@ func main() {
 					err    error
 				)
 				if c.Bool("all") {
-					builds, err = Client.ListRecentBuilds(c.Int("limit"), c.Int("offset"))
+					if Store != nil {
+						fmt.Fprintln(os.Stderr, "--all cannot be used with --offline")
+						os.Exit(1)
+					}
+					builds, err = Client.ListRecentBuildsContext(Context, c.Int("limit"), c.Int("offset"))
 				} else {
 					project := c.Generic("project").(*Project)
-					builds, err = Client.ListRecentBuildsForProject(
+					builds, err = listBuilds(
 						project.Account,
 						project.Repository,
 						c.String("branch"),
@@ -307,8 +453,11 @@ func main() {
 						c.Int("limit"),
 						c.Int("offset"))
 				}
-				if err != nil {
-					handleClientError(err)
+				warnPartial(len(builds), err)
+				defer exitIfIncomplete(err)
+
+				if printStructured(c, builds) {
+					return
 				}
 
 				t := tabwriter.NewWriter(os.Stdout, 0, 8, 4, ' ', tabwriter.StripEscape)
@@ -345,6 +494,12 @@ func main() {
 					Usage:  "Show step output",
 					EnvVar: "CIRCLE_VERBOSE",
 				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Value: 8,
+					Usage: "Maximum number of step outputs to fetch at once with --verbose",
+				},
+				formatFlag,
 			},
 			Action: func(c *cli.Context) {
 				var (
@@ -355,7 +510,7 @@ func main() {
 				)
 
 				if !c.IsSet("build-num") {
-					builds, err := Client.ListRecentBuildsForProject(project.Account, project.Repository, "", "", 1, 0)
+					builds, err := listBuilds(project.Account, project.Repository, "", "", 1, 0)
 					if err != nil {
 						handleClientError(err)
 					}
@@ -371,11 +526,15 @@ func main() {
 					buildNum = c.Int("build-num")
 				}
 
-				build, err = Client.GetBuild(project.Account, project.Repository, buildNum)
+				build, err = getBuild(project.Account, project.Repository, buildNum)
 				if err != nil {
 					handleClientError(err)
 				}
 
+				if printStructured(c, build) {
+					return
+				}
+
 				t := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
 				fmt.Fprintf(t, "Build\t%d\n", build.BuildNum)
 				fmt.Fprintf(t, "Subject\t%s\n", build.Subject)
@@ -403,16 +562,350 @@ func main() {
 				}
 				t.Flush()
 
+				nodes := []int{c.Int("build-node")}
+				if !c.IsSet("build-node") {
+					nodes = make([]int, build.Parallel)
+					for i := range nodes {
+						nodes[i] = i
+					}
+				}
+
+				var outputs *outputFetcher
+				if c.Bool("verbose") {
+					outputs = newOutputFetcher(build, nodeActions(build, nodes), c.Int("concurrency"))
+				}
+
 				if c.IsSet("build-node") {
 					fmt.Println()
-					printBuild(build, c.Int("build-node"), c.Bool("verbose"))
+					printBuild(build, c.Int("build-node"), true, outputs)
 				} else {
-					for i := 0; i < build.Parallel; i++ {
+					for _, i := range nodes {
 						fmt.Printf("\nNode %d\n", i)
-						printBuild(build, i, c.Bool("verbose"))
+						printBuild(build, i, i == nodes[0], outputs)
+					}
+				}
+			},
+		},
+		{
+			Name:  "diff",
+			Usage: "Compare the commits, build parameters, circle.yml, steps, failing tests and nodes of builds <a> and <b>",
+			Flags: []cli.Flag{
+				cli.GenericFlag{
+					Name:   "project, p",
+					Value:  currentProject,
+					Usage:  "Compare builds of specified project rather than the current",
+					EnvVar: "CIRCLE_PROJECT",
+				},
+				cli.BoolFlag{
+					Name:  "against-last-green",
+					Usage: "Compare build <b> (default to latest) with the last successful build before it",
+				},
+			},
+			Action: func(c *cli.Context) {
+				project := c.Generic("project").(*Project)
+
+				buildNums := []int{}
+				for _, arg := range c.Args() {
+					buildNum, err := strconv.Atoi(arg)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "invalid build number %q\n", arg)
+						os.Exit(1)
+					}
+					buildNums = append(buildNums, buildNum)
+				}
+
+				var from, to *circleci.Build
+				if c.Bool("against-last-green") {
+					if len(buildNums) > 1 {
+						fmt.Fprintln(os.Stderr, "must specify at most one build with --against-last-green")
+						os.Exit(1)
+					}
+
+					toNum := 0
+					if len(buildNums) == 1 {
+						toNum = buildNums[0]
+					} else {
+						toNum = latestBuild(project).BuildNum
+					}
+
+					var err error
+					to, err = getBuild(project.Account, project.Repository, toNum)
+					if err != nil {
+						handleClientError(err)
+					}
+					if to.PreviousSuccessfulBuild == nil {
+						fmt.Fprintf(os.Stderr, "build %d has no previous successful build\n", to.BuildNum)
+						os.Exit(1)
+					}
+
+					from, err = getBuild(project.Account, project.Repository, to.PreviousSuccessfulBuild.BuildNum)
+					if err != nil {
+						handleClientError(err)
+					}
+				} else {
+					if len(buildNums) != 2 {
+						fmt.Fprintln(os.Stderr, "must specify two builds to compare")
+						os.Exit(1)
+					}
+
+					var err error
+					from, err = getBuild(project.Account, project.Repository, buildNums[0])
+					if err != nil {
+						handleClientError(err)
+					}
+					to, err = getBuild(project.Account, project.Repository, buildNums[1])
+					if err != nil {
+						handleClientError(err)
+					}
+				}
+
+				tests := map[*circleci.Build][]*circleci.TestMetadata{}
+				for _, build := range []*circleci.Build{from, to} {
+					metadata, err := listTestMetadata(project.Account, project.Repository, build.BuildNum)
+					if err != nil {
+						if Context.Err() != nil {
+							handleClientError(err)
+						}
+						fmt.Fprintf(os.Stderr, "warning: unable to fetch test metadata for build %d: %s\n", build.BuildNum, err)
+					}
+					tests[build] = metadata
+				}
+
+				between, err := buildsBetween(project.Account, project.Repository, from, to)
+				if err != nil {
+					if Context.Err() != nil {
+						handleClientError(err)
 					}
+					fmt.Fprintf(os.Stderr, "warning: unable to list the builds between %d and %d, only showing the commits of build %d: %s\n", from.BuildNum, to.BuildNum, to.BuildNum, err)
+				}
+
+				diff := diffBuilds(from, to, between, tests[from], tests[to])
+
+				if printStructured(c, diff) {
+					return
+				}
+
+				printBuildDiff(os.Stdout, diff)
+			},
+		},
+		{
+			Name:  "logs",
+			Usage: "Print the output of the steps of a build",
+			Flags: []cli.Flag{
+				cli.GenericFlag{
+					Name:   "project, p",
+					Value:  currentProject,
+					Usage:  "Print output of build for specified project rather than the current",
+					EnvVar: "CIRCLE_PROJECT",
+				},
+				cli.IntFlag{
+					Name:   "build-num, n",
+					Value:  0,
+					Usage:  "Print output of specified build num (leave empty for latest)",
+					EnvVar: "CIRCLE_BUILD_NUM",
+				},
+				cli.IntFlag{
+					Name:   "build-node, i",
+					Value:  0,
+					Usage:  "For parallel builds, only print the output of the specified node",
+					EnvVar: "CIRCLE_BUILD_NODE",
+				},
+				cli.BoolFlag{
+					Name:  "follow, f",
+					Usage: "Print new output as it arrives until the build finishes",
+				},
+				intervalFlag,
+			},
+			Action: func(c *cli.Context) {
+				project := c.Generic("project").(*Project)
+				buildNum := c.Int("build-num")
+				if !c.IsSet("build-num") {
+					buildNum = latestBuild(project).BuildNum
+				}
+
+				node := -1
+				if c.IsSet("build-node") {
+					node = c.Int("build-node")
+				}
+
+				err := tailLogs(c, os.Stdout, project.Account, project.Repository, buildNum, node, c.Bool("follow"))
+				if err != nil {
+					handleClientError(err)
 				}
 			},
+			Subcommands: []cli.Command{
+				{
+					Name:  "download",
+					Usage: "Download the output of each step of a build (default to latest) into <dir>/node-<node>/<step>-<name>.log with an index.json of the steps",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "Download output of build for specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+						cli.IntFlag{
+							Name:   "build-num, n",
+							Value:  0,
+							Usage:  "Download output of specified build num (leave empty for latest)",
+							EnvVar: "CIRCLE_BUILD_NUM",
+						},
+						cli.IntFlag{
+							Name:   "build-node, i",
+							Value:  0,
+							Usage:  "For parallel builds, only download the output of the specified node",
+							EnvVar: "CIRCLE_BUILD_NODE",
+						},
+						cli.StringFlag{
+							Name:  "dir, d",
+							Value: ".",
+							Usage: "Directory to download output into",
+						},
+						cli.BoolFlag{
+							Name:  "timestamps",
+							Usage: "Prefix each line with the time it was output",
+						},
+						cli.BoolFlag{
+							Name:  "strip-ansi",
+							Usage: "Remove terminal escape sequences such as colors",
+						},
+						cli.IntFlag{
+							Name:  "concurrency, c",
+							Value: 4,
+							Usage: "Maximum number of steps to download at once",
+						},
+					},
+					Action: func(c *cli.Context) {
+						project := c.Generic("project").(*Project)
+						buildNum := c.Int("build-num")
+						if !c.IsSet("build-num") {
+							buildNum = latestBuild(project).BuildNum
+						}
+
+						build, err := getBuild(project.Account, project.Repository, buildNum)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						nodes := []int{}
+						if c.IsSet("build-node") {
+							nodes = append(nodes, c.Int("build-node"))
+						} else {
+							for i := 0; i < buildNodes(build); i++ {
+								nodes = append(nodes, i)
+							}
+						}
+
+						downloads, err := downloadLogs(build, c.String("dir"), nodes, logDownloadOptions{
+							Timestamps:  c.Bool("timestamps"),
+							StripANSI:   c.Bool("strip-ansi"),
+							Concurrency: c.Int("concurrency"),
+						})
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "unable to write index: %s\n", err)
+							os.Exit(1)
+						}
+
+						for _, dest := range sortedErrorKeys(downloads.Failures) {
+							fmt.Fprintf(os.Stderr, "failed to download %s: %s\n", dest, contextErrorMessage(downloads.Failures[dest]))
+						}
+						fmt.Printf("downloaded %d logs, %d failed\n", downloads.Downloaded, len(downloads.Failures))
+
+						exitIfIncomplete(nil)
+						if len(downloads.Failures) > 0 {
+							os.Exit(1)
+						}
+					},
+				},
+				{
+					Name:  "grep",
+					Usage: "Print lines of the output of recent builds matching the regular expression <regex> (exits with 1 if none match)",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "Search builds of specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+						cli.StringFlag{
+							Name:   "branch, b",
+							Value:  "",
+							Usage:  "Only search builds on specified branch; leave empty for all",
+							EnvVar: "CIRCLE_BRANCH",
+						},
+						cli.IntFlag{
+							Name:  "last, l",
+							Value: 50,
+							Usage: "Number of recent builds to search",
+						},
+						cli.IntFlag{
+							Name:  "context, C",
+							Value: 0,
+							Usage: "Number of lines to print before and after each matching line",
+						},
+						cli.IntFlag{
+							Name:  "concurrency, c",
+							Value: 4,
+							Usage: "Maximum number of builds to search at once",
+						},
+					},
+					Action: func(c *cli.Context) {
+						if len(c.Args()) != 1 {
+							fmt.Fprintln(os.Stderr, "must specify a regular expression")
+							os.Exit(1)
+						}
+
+						pattern, err := regexp.Compile(c.Args().Get(0))
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "invalid regular expression: %s\n", err)
+							os.Exit(1)
+						}
+
+						project := c.Generic("project").(*Project)
+
+						builds, err := listBuilds(project.Account, project.Repository, c.String("branch"), "", c.Int("last"), 0)
+						warnPartial(len(builds), err)
+						defer exitIfIncomplete(err)
+
+						// output synced by sync --outputs saves fetching it again
+						store, _ := openBuildStore(Client.BaseURL)
+
+						var (
+							results    = grepBuilds(builds, pattern, c.Int("context"), c.Int("concurrency"), store)
+							structured = c.GlobalString("output") != ""
+							printer    = &logLinePrinter{w: os.Stdout, context: c.Int("context")}
+							lines      = []*logLine{}
+							failed     = false
+						)
+						for _, result := range results {
+							<-result.done
+							if result.err != nil {
+								if Context.Err() == nil {
+									fmt.Fprintf(os.Stderr, "unable to search build %d: %s\n", result.build.BuildNum, result.err)
+									failed = true
+								}
+								continue
+							}
+
+							lines = append(lines, result.lines...)
+							if !structured {
+								for _, line := range result.lines {
+									printer.Print(line)
+								}
+							}
+						}
+
+						if structured {
+							printStructured(c, lines)
+						}
+
+						// incomplete searches exit with a failure status via exitIfIncomplete
+						if err == nil && Context.Err() == nil && (failed || len(lines) == 0) {
+							os.Exit(1)
+						}
+					},
+				},
+			},
 		},
 		{
 			Name:    "list-artifacts",
@@ -431,13 +924,14 @@ func main() {
 					Usage:  "Show artifacts for specified build num (leave empty for latest)",
 					EnvVar: "CIRCLE_BUILD_NUM",
 				},
+				formatFlag,
 			},
-			Action: func(c *cli.Context) {
+			Action: online(func(c *cli.Context) {
 				var buildNum int
 
 				project := c.Generic("project").(*Project)
 				if !c.IsSet("build-num") {
-					builds, err := Client.ListRecentBuildsForProject(project.Account, project.Repository, "", "", 1, 0)
+					builds, err := listBuilds(project.Account, project.Repository, "", "", 1, 0)
 					if err != nil {
 						handleClientError(err)
 					}
@@ -452,17 +946,88 @@ func main() {
 					buildNum = c.Int("build-num")
 				}
 
-				artifacts, err := Client.ListBuildArtifacts(project.Account, project.Repository, buildNum)
+				artifacts, err := Client.ListBuildArtifactsContext(Context, project.Account, project.Repository, buildNum)
 				if err != nil {
 					handleClientError(err)
 				}
 
+				if printStructured(c, artifacts) {
+					return
+				}
+
 				t := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
 				fmt.Fprintf(t, "Node\tPath\tURL\n")
 				for _, artifact := range artifacts {
 					fmt.Fprintf(t, "%d\t%s\t%s\n", artifact.NodeIndex, artifact.Path, artifact.URL)
 				}
 				t.Flush()
+			}),
+			Subcommands: []cli.Command{
+				{
+					Name:  "download",
+					Usage: "Download artifacts for build (default to latest) into <dir>/<node>/<path>",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "Download artifacts for specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+						cli.IntFlag{
+							Name:   "build-num, n",
+							Value:  0,
+							Usage:  "Download artifacts for specified build num (leave empty for latest)",
+							EnvVar: "CIRCLE_BUILD_NUM",
+						},
+						cli.StringFlag{
+							Name:  "dir, d",
+							Value: ".",
+							Usage: "Directory to download artifacts into",
+						},
+						cli.StringSliceFlag{
+							Name:  "include, i",
+							Value: &cli.StringSlice{},
+							Usage: "Only download artifacts whose path or file name matches the glob (can be repeated)",
+						},
+						cli.StringSliceFlag{
+							Name:  "exclude, x",
+							Value: &cli.StringSlice{},
+							Usage: "Skip artifacts whose path or file name matches the glob (can be repeated)",
+						},
+						cli.IntFlag{
+							Name:  "concurrency, c",
+							Value: 4,
+							Usage: "Maximum number of artifacts to download at once",
+						},
+					},
+					Action: online(func(c *cli.Context) {
+						project := c.Generic("project").(*Project)
+						buildNum := c.Int("build-num")
+						if !c.IsSet("build-num") {
+							buildNum = latestBuild(project).BuildNum
+						}
+
+						artifacts, err := Client.ListBuildArtifactsContext(Context, project.Account, project.Repository, buildNum)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						artifacts, err = filterArtifacts(artifacts, c.StringSlice("include"), c.StringSlice("exclude"))
+						if err != nil {
+							fmt.Fprintln(os.Stderr, err)
+							os.Exit(1)
+						}
+
+						downloads := downloadArtifacts(artifacts, c.String("dir"), c.Int("concurrency"))
+
+						printArtifactDownloads(os.Stdout, os.Stderr, downloads)
+
+						exitIfIncomplete(nil)
+						if len(downloads.Failures) > 0 {
+							os.Exit(1)
+						}
+					}),
+				},
 			},
 		},
 		{
@@ -481,6 +1046,12 @@ func main() {
 					Usage:  "Show test metadata for specified build num (leave empty for latest)",
 					EnvVar: "CIRCLE_BUILD_NUM",
 				},
+				cli.StringFlag{
+					Name:  "junit",
+					Value: "",
+					Usage: "Write test metadata as JUnit XML to the specified file (- for stdout)",
+				},
+				formatFlag,
 			},
 			Action: func(c *cli.Context) {
 				var buildNum int
@@ -491,11 +1062,33 @@ func main() {
 					buildNum = latestBuild(project).BuildNum
 				}
 
-				metadata, err := Client.ListTestMetadata(project.Account, project.Repository, buildNum)
+				metadata, err := listTestMetadata(project.Account, project.Repository, buildNum)
 				if err != nil {
 					handleClientError(err)
 				}
 
+				if c.IsSet("junit") {
+					w := os.Stdout
+					if c.String("junit") != "-" {
+						w, err = os.Create(c.String("junit"))
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "unable to create %s: %s\n", c.String("junit"), err)
+							os.Exit(1)
+						}
+						defer w.Close()
+					}
+
+					if err := writeJUnit(w, metadata); err != nil {
+						fmt.Fprintf(os.Stderr, "unable to write JUnit XML: %s\n", err)
+						os.Exit(1)
+					}
+					return
+				}
+
+				if printStructured(c, metadata) {
+					return
+				}
+
 				for _, metadatum := range metadata {
 					fmt.Printf("%s: %s %s (%s)\n",
 						metadatum.File,
@@ -509,48 +1102,235 @@ func main() {
 			},
 		},
 		{
-			Name:    "retry-build",
-			Aliases: []string{"retry"},
-			Usage:   "Retry a build",
+			Name:  "flaky-tests",
+			Usage: "Find tests which both passed and failed on the same revision or which failed in a single build in recent builds",
 			Flags: []cli.Flag{
 				cli.GenericFlag{
 					Name:   "project, p",
 					Value:  currentProject,
-					Usage:  "Show build for specified project rather than the current",
+					Usage:  "Find flaky tests for specified project rather than the current",
 					EnvVar: "CIRCLE_PROJECT",
 				},
+				cli.StringFlag{
+					Name:   "branch, b",
+					Value:  "",
+					Usage:  "Only look at builds on specified branch; leave empty for all",
+					EnvVar: "CIRCLE_BRANCH",
+				},
 				cli.IntFlag{
-					Name:   "build-num, n",
-					Value:  0,
-					Usage:  "Retry specified build num (leave empty for latest)",
-					EnvVar: "CIRCLE_BUILD_NUM",
+					Name:  "last, l",
+					Value: 30,
+					Usage: "Number of recent builds to look at",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Value: 4,
+					Usage: "Maximum number of builds to fetch test metadata for at once",
 				},
 			},
 			Action: func(c *cli.Context) {
 				project := c.Generic("project").(*Project)
 
-				buildNum := c.Int("build-num")
-				if !c.IsSet("build-num") {
-					buildNum = latestBuild(project).BuildNum
-				}
+				builds, err := listBuilds(project.Account, project.Repository, c.String("branch"), "completed", c.Int("last"), 0)
+				warnPartial(len(builds), err)
+				defer exitIfIncomplete(err)
 
-				build, err := Client.RetryBuild(project.Account, project.Repository, buildNum)
-				if err != nil {
-					handleClientError(err)
+				tests := findFlakyTests(buildTestMetadata(builds, c.Int("concurrency"), os.Stderr))
+
+				if printStructured(c, tests) {
+					return
 				}
 
-				fmt.Println(buildURL(build, c.GlobalString("host")))
+				printFlakyTests(os.Stdout, tests)
 			},
 		},
 		{
-			Name:    "cancel-build",
-			Aliases: []string{"cancel"},
-			Usage:   "Cancel a build",
+			Name:  "stats",
+			Usage: "Show pass rate, duration and queue time statistics for recent builds",
 			Flags: []cli.Flag{
 				cli.GenericFlag{
 					Name:   "project, p",
 					Value:  currentProject,
-					Usage:  "Cancel build for specified project rather than the current",
+					Usage:  "Show statistics for specified project rather than the current",
+					EnvVar: "CIRCLE_PROJECT",
+				},
+				cli.StringFlag{
+					Name:   "branch, b",
+					Value:  "",
+					Usage:  "Only look at builds on specified branch; leave empty for all",
+					EnvVar: "CIRCLE_BRANCH",
+				},
+				cli.IntFlag{
+					Name:  "last, l",
+					Value: 100,
+					Usage: "Number of recent builds to look at -- set to -1 for all",
+				},
+			},
+			Action: func(c *cli.Context) {
+				project := c.Generic("project").(*Project)
+
+				builds, err := listBuilds(project.Account, project.Repository, c.String("branch"), "", c.Int("last"), 0)
+				warnPartial(len(builds), err)
+				defer exitIfIncomplete(err)
+
+				report := computeStats(builds)
+
+				if printStructured(c, report) {
+					return
+				}
+
+				printStats(os.Stdout, report)
+			},
+		},
+		{
+			Name:  "step-times",
+			Usage: "Compare step durations of the most recent builds against older builds to find regressions",
+			Flags: []cli.Flag{
+				cli.GenericFlag{
+					Name:   "project, p",
+					Value:  currentProject,
+					Usage:  "Compare step durations for specified project rather than the current",
+					EnvVar: "CIRCLE_PROJECT",
+				},
+				cli.StringFlag{
+					Name:   "branch, b",
+					Value:  "",
+					Usage:  "Only look at builds on specified branch; leave empty for all",
+					EnvVar: "CIRCLE_BRANCH",
+				},
+				cli.IntFlag{
+					Name:  "last, l",
+					Value: 30,
+					Usage: "Number of recent builds to look at",
+				},
+				cli.IntFlag{
+					Name:  "recent, r",
+					Value: 5,
+					Usage: "Number of the most recent builds to compare against the rest",
+				},
+				cli.Float64Flag{
+					Name:  "factor",
+					Value: 1.5,
+					Usage: "Consider a step regressed if its recent median is more than this many times its older median",
+				},
+				cli.DurationFlag{
+					Name:  "min-increase",
+					Value: 10 * time.Second,
+					Usage: "Ignore regressions where the median increased by less than this",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Value: 4,
+					Usage: "Maximum number of builds to fetch at once",
+				},
+			},
+			Action: func(c *cli.Context) {
+				project := c.Generic("project").(*Project)
+
+				builds, err := listBuilds(project.Account, project.Repository, c.String("branch"), "completed", c.Int("last"), 0)
+				warnPartial(len(builds), err)
+				defer exitIfIncomplete(err)
+
+				builds = fetchBuildDetails(builds, c.Int("concurrency"), os.Stderr)
+				timings := compareStepTimes(builds, c.Int("recent"), c.Float64("factor"), c.Duration("min-increase"))
+
+				if printStructured(c, timings) {
+					return
+				}
+
+				printStepTimes(os.Stdout, timings)
+			},
+		},
+		{
+			Name:  "sync",
+			Usage: "Mirror builds into the local store used by --offline (expects projects as <account>/<repo> arguments; defaults to the current project)",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "limit, l",
+					Value: 100,
+					Usage: "Number of recent builds to fetch the first time a project is synced -- set to -1 for all",
+				},
+				cli.BoolFlag{
+					Name:  "tests",
+					Usage: "Also store test metadata of finished builds",
+				},
+				cli.BoolFlag{
+					Name:  "outputs",
+					Usage: "Also store step output of finished builds",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Value: 4,
+					Usage: "Maximum number of builds to fetch at once",
+				},
+			},
+			Action: online(func(c *cli.Context) {
+				projects := []*Project{}
+				for _, arg := range c.Args() {
+					project := &Project{}
+					if err := project.Set(arg); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+					projects = append(projects, project)
+				}
+				if len(projects) == 0 {
+					projects = append(projects, currentProject)
+				}
+
+				store, err := openBuildStore(Client.BaseURL)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				opts := syncOptions{
+					limit:        c.Int("limit"),
+					testMetadata: c.Bool("tests"),
+					outputs:      c.Bool("outputs"),
+					concurrency:  c.Int("concurrency"),
+				}
+
+				type syncResult struct {
+					Project string `json:"project"`
+					Synced  int    `json:"synced"`
+				}
+
+				var (
+					results = []syncResult{}
+					lastErr error
+				)
+				for _, project := range projects {
+					if Context.Err() != nil {
+						break
+					}
+
+					synced, err := syncProject(store, project.Account, project.Repository, opts)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "unable to sync %s: %s\n", project, contextErrorMessage(err))
+						lastErr = err
+					}
+					results = append(results, syncResult{Project: project.String(), Synced: synced})
+				}
+
+				if !printStructured(c, results) {
+					for _, result := range results {
+						fmt.Printf("synced %d builds of %s\n", result.Synced, result.Project)
+					}
+				}
+
+				exitIfIncomplete(lastErr)
+			}),
+		},
+		{
+			Name:    "retry-build",
+			Aliases: []string{"retry"},
+			Usage:   "Retry a build",
+			Flags: []cli.Flag{
+				cli.GenericFlag{
+					Name:   "project, p",
+					Value:  currentProject,
+					Usage:  "Show build for specified project rather than the current",
 					EnvVar: "CIRCLE_PROJECT",
 				},
 				cli.IntFlag{
@@ -559,21 +1339,103 @@ func main() {
 					Usage:  "Retry specified build num (leave empty for latest)",
 					EnvVar: "CIRCLE_BUILD_NUM",
 				},
+				cli.BoolFlag{
+					Name:  "wait, w",
+					Usage: "Wait for the build to finish and exit with a status matching its outcome (see watch)",
+				},
+				intervalFlag,
 			},
-			Action: func(c *cli.Context) {
+			Action: online(func(c *cli.Context) {
 				project := c.Generic("project").(*Project)
+
 				buildNum := c.Int("build-num")
 				if !c.IsSet("build-num") {
 					buildNum = latestBuild(project).BuildNum
 				}
 
-				build, err := Client.CancelBuild(project.Account, project.Repository, buildNum)
+				build, err := Client.RetryBuildContext(Context, project.Account, project.Repository, buildNum)
 				if err != nil {
 					handleClientError(err)
 				}
 
+				if c.Bool("wait") {
+					if c.GlobalString("output") == "" {
+						fmt.Println(buildURL(build, c.GlobalString("host")))
+					}
+					os.Exit(watchBuild(c, build.Username, build.Reponame, build.BuildNum))
+				}
+
+				if printStructured(c, build) {
+					return
+				}
+
+				fmt.Println(buildURL(build, c.GlobalString("host")))
+			}),
+		},
+		{
+			Name:    "cancel-build",
+			Aliases: []string{"cancel"},
+			Usage:   "Cancel a build",
+			Flags: []cli.Flag{
+				cli.GenericFlag{
+					Name:   "project, p",
+					Value:  currentProject,
+					Usage:  "Cancel build for specified project rather than the current",
+					EnvVar: "CIRCLE_PROJECT",
+				},
+				cli.IntFlag{
+					Name:   "build-num, n",
+					Value:  0,
+					Usage:  "Retry specified build num (leave empty for latest)",
+					EnvVar: "CIRCLE_BUILD_NUM",
+				},
+			},
+			Action: online(func(c *cli.Context) {
+				project := c.Generic("project").(*Project)
+				buildNum := c.Int("build-num")
+				if !c.IsSet("build-num") {
+					buildNum = latestBuild(project).BuildNum
+				}
+
+				build, err := Client.CancelBuildContext(Context, project.Account, project.Repository, buildNum)
+				if err != nil {
+					handleClientError(err)
+				}
+
+				if printStructured(c, build) {
+					return
+				}
+
 				fmt.Printf("canceled build %d\n", build.BuildNum)
+			}),
+		},
+		{
+			Name:  "watch",
+			Usage: "Follow a build until it finishes, exiting with a status matching its outcome (0: success, 2: failed, 3: timedout, 4: canceled, 5: infrastructure_fail, 6: other)",
+			Flags: []cli.Flag{
+				cli.GenericFlag{
+					Name:   "project, p",
+					Value:  currentProject,
+					Usage:  "Watch build for specified project rather than the current",
+					EnvVar: "CIRCLE_PROJECT",
+				},
+				cli.IntFlag{
+					Name:   "build-num, n",
+					Value:  0,
+					Usage:  "Watch specified build num (leave empty for latest)",
+					EnvVar: "CIRCLE_BUILD_NUM",
+				},
+				intervalFlag,
 			},
+			Action: online(func(c *cli.Context) {
+				project := c.Generic("project").(*Project)
+				buildNum := c.Int("build-num")
+				if !c.IsSet("build-num") {
+					buildNum = latestBuild(project).BuildNum
+				}
+
+				os.Exit(watchBuild(c, project.Account, project.Repository, buildNum))
+			}),
 		},
 		{
 			Name:  "build",
@@ -591,13 +1453,49 @@ func main() {
 					Usage:  "Branch to trigger build on (leave empty for default branch)",
 					EnvVar: "CIRCLE_BRANCH",
 				},
+				cli.StringFlag{
+					Name:  "revision, r",
+					Value: "",
+					Usage: "Specific revision to build (leave empty for the head of the branch)",
+				},
+				cli.StringFlag{
+					Name:  "tag",
+					Value: "",
+					Usage: "Tag to build (cannot be used with --branch)",
+				},
+				cli.IntFlag{
+					Name:  "parallel",
+					Value: 0,
+					Usage: "Number of containers to use (leave empty for the project setting)",
+				},
+				cli.StringSliceFlag{
+					Name:  "build-parameter, e",
+					Value: &cli.StringSlice{},
+					Usage: "Build parameter to pass as an environment variable to the build as KEY=VALUE (can be repeated)",
+				},
+				cli.BoolFlag{
+					Name:  "wait, w",
+					Usage: "Wait for the build to finish and exit with a status matching its outcome (see watch)",
+				},
+				intervalFlag,
 			},
-			Action: func(c *cli.Context) {
+			Action: online(func(c *cli.Context) {
 				project := c.Generic("project").(*Project)
 
+				if c.IsSet("tag") && c.IsSet("branch") {
+					fmt.Fprintln(os.Stderr, "--tag cannot be used with --branch")
+					os.Exit(1)
+				}
+
+				buildParameters, err := parseBuildParameters(c.StringSlice("build-parameter"))
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+
 				branch := c.String("branch")
-				if !c.IsSet("branch") {
-					p, err := Client.GetProject(project.Account, project.Repository)
+				if !c.IsSet("branch") && !c.IsSet("tag") {
+					p, err := Client.GetProjectContext(Context, project.Account, project.Repository)
 					if err != nil {
 						fmt.Fprintln(os.Stderr, err)
 						os.Exit(1)
@@ -605,13 +1503,34 @@ func main() {
 					branch = p.DefaultBranch
 				}
 
-				build, err := Client.Build(project.Account, project.Repository, branch)
+				build, err := Client.BuildOptsContext(Context, project.Account, project.Repository, branch, &circleci.BuildOptions{
+					Revision:        c.String("revision"),
+					Tag:             c.String("tag"),
+					Parallel:        c.Int("parallel"),
+					BuildParameters: buildParameters,
+				})
 				if err != nil {
 					handleClientError(err)
 				}
 
-				fmt.Println(buildURL(build, c.GlobalString("host")))
-			},
+				if c.GlobalString("output") == "" {
+					fmt.Println(buildURL(build, c.GlobalString("host")))
+					if len(build.BuildParameters) > 0 {
+						t := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+						fmt.Fprintf(t, "Build Parameters\t\n")
+						for _, key := range sortedKeys(build.BuildParameters) {
+							fmt.Fprintf(t, "\t%s\t%s\n", key, build.BuildParameters[key])
+						}
+						t.Flush()
+					}
+				}
+
+				if c.Bool("wait") {
+					os.Exit(watchBuild(c, build.Username, build.Reponame, build.BuildNum))
+				}
+
+				printStructured(c, build)
+			}),
 		},
 		{
 			Name:  "clear-cache",
@@ -624,20 +1543,26 @@ func main() {
 					EnvVar: "CIRCLE_PROJECT",
 				},
 			},
-			Action: func(c *cli.Context) {
+			Action: online(func(c *cli.Context) {
 				project := c.Generic("project").(*Project)
 
-				status, err := Client.ClearCache(project.Account, project.Repository)
+				status, err := Client.ClearCacheContext(Context, project.Account, project.Repository)
 				if err != nil {
 					handleClientError(err)
 				}
 
+				if printStructured(c, struct {
+					Status string `json:"status"`
+				}{status}) {
+					return
+				}
+
 				fmt.Println(status)
-			},
+			}),
 		},
 		{
 			Name:  "add-env-var",
-			Usage: "Add an environment variable to the project (expects the name and value as arguments)",
+			Usage: "Add an environment variable to the project (expects the name as argument; the value is read from --from-file, stdin or a prompt)",
 			Flags: []cli.Flag{
 				cli.GenericFlag{
 					Name:   "project, p",
@@ -645,23 +1570,43 @@ func main() {
 					Usage:  "Add env var to specified project rather than the current",
 					EnvVar: "CIRCLE_PROJECT",
 				},
+				fromFileFlag,
 			},
-			Action: func(c *cli.Context) {
-				if len(c.Args()) != 2 {
-					fmt.Fprintln(os.Stderr, "must specify name and value")
+			Action: online(func(c *cli.Context) {
+				if len(c.Args()) != 1 && len(c.Args()) != 2 {
+					fmt.Fprintln(os.Stderr, "must specify name")
 					os.Exit(1)
 				}
 
-				name, value := c.Args().Get(0), c.Args().Get(1)
+				name := c.Args().Get(0)
 				project := c.Generic("project").(*Project)
 
-				_, err := Client.AddEnvVar(project.Account, project.Repository, name, value)
+				value := c.Args().Get(1)
+				if len(c.Args()) == 2 {
+					fmt.Fprintln(os.Stderr, "warning: values given as arguments are visible in shell history and to other users, use --from-file or stdin instead")
+				} else {
+					var err error
+					value, err = readSecret(c, fmt.Sprintf("Value of %s: ", name), false)
+					if err != nil {
+						if Context.Err() != nil {
+							handleClientError(err)
+						}
+						fmt.Fprintf(os.Stderr, "unable to read value: %s\n", err)
+						os.Exit(1)
+					}
+				}
+
+				envVar, err := Client.AddEnvVarContext(Context, project.Account, project.Repository, name, value)
 				if err != nil {
 					handleClientError(err)
 				}
 
-				fmt.Printf("added %s=%s\n", name, value)
-			},
+				if printStructured(c, envVar) {
+					return
+				}
+
+				fmt.Printf("added %s\n", name)
+			}),
 		},
 		{
 			Name:  "list-env-vars",
@@ -674,18 +1619,22 @@ func main() {
 					EnvVar: "CIRCLE_PROJECT",
 				},
 			},
-			Action: func(c *cli.Context) {
+			Action: online(func(c *cli.Context) {
 				project := c.Generic("project").(*Project)
 
-				envVars, err := Client.ListEnvVars(project.Account, project.Repository)
+				envVars, err := Client.ListEnvVarsContext(Context, project.Account, project.Repository)
 				if err != nil {
 					handleClientError(err)
 				}
 
+				if printStructured(c, envVars) {
+					return
+				}
+
 				for _, envVar := range envVars {
 					fmt.Printf("%s=%s\n", envVar.Name, envVar.Value)
 				}
-			},
+			}),
 		},
 		{
 			Name:  "delete-env-var",
@@ -698,7 +1647,7 @@ func main() {
 					EnvVar: "CIRCLE_PROJECT",
 				},
 			},
-			Action: func(c *cli.Context) {
+			Action: online(func(c *cli.Context) {
 				if len(c.Args()) != 1 {
 					fmt.Fprintln(os.Stderr, "must specify name")
 					os.Exit(1)
@@ -707,17 +1656,332 @@ func main() {
 				name := c.Args().Get(0)
 				project := c.Generic("project").(*Project)
 
-				err := Client.DeleteEnvVar(project.Account, project.Repository, name)
+				err := Client.DeleteEnvVarContext(Context, project.Account, project.Repository, name)
 				if err != nil {
 					handleClientError(err)
 				}
 
+				if printStructured(c, struct {
+					Name string `json:"name"`
+				}{name}) {
+					return
+				}
+
 				fmt.Printf("deleted %s\n", name)
+			}),
+		},
+		{
+			Name:  "checkout-keys",
+			Usage: "Manage the keys used to check out the project",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "List the checkout keys of the project",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "List checkout keys of specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+					},
+					Action: online(func(c *cli.Context) {
+						project := c.Generic("project").(*Project)
+
+						keys, err := Client.ListCheckoutKeysContext(Context, project.Account, project.Repository)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						if printStructured(c, keys) {
+							return
+						}
+
+						printCheckoutKeys(os.Stdout, keys)
+					}),
+				},
+				{
+					Name:  "create",
+					Usage: "Create a checkout key for the project of type <type>, either deploy-key or github-user-key",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "Create checkout key for specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+					},
+					Action: online(func(c *cli.Context) {
+						if len(c.Args()) != 1 {
+							fmt.Fprintln(os.Stderr, "must specify type")
+							os.Exit(1)
+						}
+
+						keyType := c.Args().Get(0)
+						if keyType != "deploy-key" && keyType != "github-user-key" {
+							fmt.Fprintf(os.Stderr, "invalid type %q, must be one of deploy-key,github-user-key\n", keyType)
+							os.Exit(1)
+						}
+
+						project := c.Generic("project").(*Project)
+
+						key, err := Client.CreateCheckoutKeyContext(Context, project.Account, project.Repository, keyType)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						if printStructured(c, key) {
+							return
+						}
+
+						printCheckoutKeys(os.Stdout, []*circleci.CheckoutKey{key})
+					}),
+				},
+				{
+					Name:  "show",
+					Usage: "Show the checkout key of the project with fingerprint <fingerprint>, including its public key",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "Show checkout key of specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+					},
+					Action: online(func(c *cli.Context) {
+						if len(c.Args()) != 1 {
+							fmt.Fprintln(os.Stderr, "must specify fingerprint")
+							os.Exit(1)
+						}
+
+						fingerprint := c.Args().Get(0)
+						project := c.Generic("project").(*Project)
+
+						key, err := Client.GetCheckoutKeyContext(Context, project.Account, project.Repository, fingerprint)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						if printStructured(c, key) {
+							return
+						}
+
+						printCheckoutKeys(os.Stdout, []*circleci.CheckoutKey{key})
+						fmt.Printf("\n%s\n", strings.TrimSpace(key.PublicKey))
+					}),
+				},
+				{
+					Name:  "delete",
+					Usage: "Delete the checkout key of the project with fingerprint <fingerprint>",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "Delete checkout key of specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+						yesFlag,
+					},
+					Action: online(func(c *cli.Context) {
+						if len(c.Args()) != 1 {
+							fmt.Fprintln(os.Stderr, "must specify fingerprint")
+							os.Exit(1)
+						}
+
+						fingerprint := c.Args().Get(0)
+						project := c.Generic("project").(*Project)
+
+						// fetch the key first so the user knows what they are deleting
+						key, err := Client.GetCheckoutKeyContext(Context, project.Account, project.Repository, fingerprint)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						ok, err := confirm(c, fmt.Sprintf("Delete %s %s from %s/%s?", key.Type, key.Fingerprint, project.Account, project.Repository))
+						if err != nil {
+							fmt.Fprintln(os.Stderr, err)
+							os.Exit(1)
+						}
+						if !ok {
+							fmt.Fprintln(os.Stderr, "not deleted")
+							os.Exit(1)
+						}
+
+						err = Client.DeleteCheckoutKeyContext(Context, project.Account, project.Repository, fingerprint)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						if printStructured(c, struct {
+							Fingerprint string `json:"fingerprint"`
+						}{fingerprint}) {
+							return
+						}
+
+						fmt.Printf("deleted %s\n", fingerprint)
+					}),
+				},
+			},
+		},
+		{
+			Name:  "env",
+			Usage: "Manage the environment variables of projects in bulk",
+			Subcommands: []cli.Command{
+				{
+					Name:  "import",
+					Usage: "Add the environment variables in dotenv file <file> (- for stdin) to the project, showing the changes before making them",
+					Flags: []cli.Flag{
+						cli.GenericFlag{
+							Name:   "project, p",
+							Value:  currentProject,
+							Usage:  "Import env vars into specified project rather than the current",
+							EnvVar: "CIRCLE_PROJECT",
+						},
+						cli.BoolFlag{
+							Name:  "overwrite",
+							Usage: "Replace variables which are already set (their values are masked so cannot be compared)",
+						},
+						cli.BoolFlag{
+							Name:  "prune",
+							Usage: "Delete variables which are not in the file",
+						},
+						cli.BoolFlag{
+							Name:  "apply",
+							Usage: "Make the changes without asking for confirmation",
+						},
+					},
+					Action: online(func(c *cli.Context) {
+						if len(c.Args()) != 1 {
+							fmt.Fprintln(os.Stderr, "must specify file")
+							os.Exit(1)
+						}
+
+						filename := c.Args().Get(0)
+						project := c.Generic("project").(*Project)
+
+						var r io.Reader = stdinReader
+						if filename != "-" {
+							f, err := os.Open(filename)
+							if err != nil {
+								fmt.Fprintf(os.Stderr, "unable to open %s: %s\n", filename, err)
+								os.Exit(1)
+							}
+							defer f.Close()
+							r = f
+						}
+
+						vars, err := parseDotenv(r, filename)
+						if err != nil {
+							fmt.Fprintln(os.Stderr, err)
+							os.Exit(1)
+						}
+
+						existing, err := Client.ListEnvVarsContext(Context, project.Account, project.Repository)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						changes := planEnvImport(vars, existing, c.Bool("overwrite"), c.Bool("prune"))
+
+						if !printStructured(c, changes) {
+							printEnvPlan(os.Stdout, changes)
+						}
+
+						if pendingEnvChanges(changes) == 0 {
+							return
+						}
+
+						if !c.Bool("apply") {
+							if filename == "-" || !isatty.IsTerminal(os.Stdin.Fd()) {
+								fmt.Fprintln(os.Stderr, "\nno changes made, run with --apply to make them")
+								return
+							}
+
+							ok, err := confirm(c, fmt.Sprintf("\nMake these changes to %s/%s?", project.Account, project.Repository))
+							if err != nil {
+								if Context.Err() != nil {
+									handleClientError(err)
+								}
+								fmt.Fprintln(os.Stderr, err)
+								os.Exit(1)
+							}
+							if !ok {
+								fmt.Fprintln(os.Stderr, "no changes made")
+								os.Exit(1)
+							}
+						}
+
+						if failures := applyEnvChanges(os.Stdout, os.Stderr, project.Account, project.Repository, changes); failures > 0 {
+							os.Exit(1)
+						}
+					}),
+				},
+				{
+					Name:  "audit",
+					Usage: "Print the visible end of the masked values of environment variables across all projects, e.g. to find projects still using a secret after rotating it",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name-regex, n",
+							Value: "",
+							Usage: "Only show variables whose names match the regular expression; leave empty for all",
+						},
+						cli.StringFlag{
+							Name:  "account, a",
+							Value: "",
+							Usage: "Only audit projects of the account; leave empty for all",
+						},
+						cli.IntFlag{
+							Name:  "concurrency, c",
+							Value: 4,
+							Usage: "Maximum number of projects to audit at once",
+						},
+						formatFlag,
+					},
+					Action: online(func(c *cli.Context) {
+						pattern, err := regexp.Compile(c.String("name-regex"))
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "invalid regular expression: %s\n", err)
+							os.Exit(1)
+						}
+
+						projects, err := Client.ListProjectsContext(Context)
+						if err != nil {
+							handleClientError(err)
+						}
+
+						if account := c.String("account"); account != "" {
+							filtered := []*circleci.Project{}
+							for _, project := range projects {
+								if strings.EqualFold(project.Username, account) {
+									filtered = append(filtered, project)
+								}
+							}
+							projects = filtered
+						}
+
+						audited, failures := auditEnvVars(projects, pattern, c.Int("concurrency"), os.Stderr)
+						if Context.Err() != nil {
+							handleClientError(Context.Err())
+						}
+
+						if !printStructured(c, audited) {
+							if len(audited) > 0 {
+								printEnvAudit(os.Stdout, audited)
+								fmt.Println()
+							}
+							fmt.Printf("%d of %d projects have matching variables\n", len(audited), len(projects))
+						}
+
+						if failures > 0 {
+							os.Exit(1)
+						}
+					}),
+				},
 			},
 		},
 		{
 			Name:  "add-ssh-key",
-			Usage: "Add an SSH key to be used to access external systems (expects the hostname and private key as arguments)",
+			Usage: "Add an SSH key to be used to access external systems (expects the hostname as argument; the PEM encoded private key is read from --from-file, stdin or a prompt)",
 			Flags: []cli.Flag{
 				cli.GenericFlag{
 					Name:   "project, p",
@@ -725,23 +1989,52 @@ func main() {
 					Usage:  "Add SSH key to specified project rather than the current",
 					EnvVar: "CIRCLE_PROJECT",
 				},
+				fromFileFlag,
 			},
-			Action: func(c *cli.Context) {
-				if len(c.Args()) != 2 {
-					fmt.Fprintln(os.Stderr, "must specify hostname and private key")
+			Action: online(func(c *cli.Context) {
+				if len(c.Args()) != 1 && len(c.Args()) != 2 {
+					fmt.Fprintln(os.Stderr, "must specify hostname")
 					os.Exit(1)
 				}
 
-				hostname, privateKey := c.Args().Get(0), c.Args().Get(1)
+				hostname := c.Args().Get(0)
 				project := c.Generic("project").(*Project)
 
-				err := Client.AddSSHKey(project.Account, project.Repository, hostname, privateKey)
+				privateKey := c.Args().Get(1)
+				if len(c.Args()) == 2 {
+					fmt.Fprintln(os.Stderr, "warning: keys given as arguments are visible in shell history and to other users, use --from-file or stdin instead")
+				} else {
+					var err error
+					privateKey, err = readSecret(c, fmt.Sprintf("Paste the private key for %s, then press Ctrl-D: ", hostname), true)
+					if err != nil {
+						if Context.Err() != nil {
+							handleClientError(err)
+						}
+						fmt.Fprintf(os.Stderr, "unable to read private key: %s\n", err)
+						os.Exit(1)
+					}
+				}
+
+				fingerprint, err := parsePrivateKey(privateKey)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				err = Client.AddSSHKeyContext(Context, project.Account, project.Repository, hostname, privateKey)
 				if err != nil {
 					handleClientError(err)
 				}
 
-				fmt.Printf("added key for %s\n", hostname)
-			},
+				if printStructured(c, struct {
+					Hostname    string `json:"hostname"`
+					Fingerprint string `json:"fingerprint"`
+				}{hostname, fingerprint}) {
+					return
+				}
+
+				fmt.Printf("added key %s for %s\n", fingerprint, hostname)
+			}),
 		},
 	}
 
@@ -788,11 +2081,15 @@ func getCurrentProject() *Project {
 	return &Project{}
 }
 
-func printBuild(build *circleci.Build, i int, verbose bool) {
+// printBuild prints the steps of the given node of the build
+// Non-parallel steps are shared by all nodes so are only printed if shared is true, i.e. for the
+// first node printed, as with logs
+// If fetcher is not nil, the output of each step is printed as well
+func printBuild(build *circleci.Build, i int, shared bool, fetcher *outputFetcher) {
 	for _, step := range build.Steps {
-		action := step.Actions[0]
-		if action.Parallel {
-			action = step.Actions[i]
+		action := stepAction(step, i)
+		if action == nil || (!action.Parallel && !shared) {
+			continue
 		}
 
 		colorSprintfFunc := statusSprintfFunc(action.Status)
@@ -806,31 +2103,75 @@ func printBuild(build *circleci.Build, i int, verbose bool) {
 			fmt.Printf("\t%s\n", action.Name)
 		}
 
-		if verbose && action.HasOutput {
-			outputs, err := Client.GetActionOutputs(action)
+		if fetcher != nil && action.HasOutput {
+			err := fetcher.Outputs(action, func(output *circleci.Output) {
+				fmt.Println(strings.Trim(output.Message, "\n"))
+			})
 			if err != nil {
+				if Context.Err() != nil {
+					handleClientError(err)
+				}
 				fmt.Fprintf(os.Stderr, "error retrieving action output: %s\n", err)
 			}
-			for _, output := range outputs {
-				fmt.Println(strings.Trim(output.Message, "\n"))
-			}
 			fmt.Println()
 		}
 	}
 }
 
+// parseBuildParameters parses KEY=VALUE pairs into a map
+func parseBuildParameters(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	parameters := map[string]string{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("could not parse build parameter %s as 'KEY=VALUE'", pair)
+		}
+		parameters[parts[0]] = parts[1]
+	}
+
+	return parameters, nil
+}
+
+// sortedKeys returns the keys of the map in sorted order so that output is stable
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printCheckoutKeys(w io.Writer, keys []*circleci.CheckoutKey) {
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(t, "Fingerprint\tType\tLogin\tPreferred\tCreated\n")
+	for _, key := range keys {
+		login := ""
+		if key.Login != nil {
+			login = *key.Login
+		}
+		fmt.Fprintf(t, "%s\t%s\t%s\t%t\t%s\n", key.Fingerprint, key.Type, login, key.Preferred, key.Time)
+	}
+	t.Flush()
+}
+
 func buildURL(build *circleci.Build, host string) string {
 	return fmt.Sprintf("%s/gh/%s/%s/%d", host, build.Username, build.Reponame, build.BuildNum)
 }
 
 func latestBuild(project *Project) *circleci.Build {
-	builds, err := Client.ListRecentBuildsForProject(project.Account, project.Repository, "", "", 1, 0)
+	builds, err := listBuilds(project.Account, project.Repository, "", "", 1, 0)
 	if err != nil {
 		handleClientError(err)
 	}
 
 	if len(builds) == 0 {
-		handleClientError(err)
+		fmt.Fprintln(os.Stderr, "no builds")
+		os.Exit(1)
 	}
 	return builds[0]
 }
@@ -840,6 +2181,14 @@ func handleClientError(err error) {
 		return
 	}
 
+	if Context.Err() != nil {
+		fmt.Fprintln(os.Stderr, contextErrorMessage(err))
+		if Context.Err() == context.Canceled {
+			os.Exit(exitInterrupted)
+		}
+		os.Exit(1)
+	}
+
 	switch err := err.(type) {
 	case nil:
 		return
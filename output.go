@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+var validOutputFormats = []string{"json", "yaml", "tsv"}
+
+// tsvEscaper escapes characters that would otherwise break up TSV rows and columns
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func isValidOutputFormat(format string) bool {
+	for _, valid := range validOutputFormats {
+		if format == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// printStructured renders v using the template given by --format, if the command supports it, or
+// in the format requested by the global --output flag
+// Returns false, without printing anything, if no structured output was requested so that
+// the caller can fall back to its human readable output
+func printStructured(c *cli.Context, v interface{}) bool {
+	if printFormatted(c, v) {
+		return true
+	}
+
+	format := c.GlobalString("output")
+	if format == "" {
+		return false
+	}
+
+	if err := render(os.Stdout, format, v); err != nil {
+		fmt.Fprintf(os.Stderr, "error rendering output: %s\n", err)
+		os.Exit(1)
+	}
+
+	return true
+}
+
+// render writes v to w in the given format
+// v is expected to be one of the go-circleci structs (or a slice of them)
+func render(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	case "yaml":
+		return renderYAML(w, v)
+	case "tsv":
+		return renderTSV(w, v)
+	default:
+		return fmt.Errorf("must be one of %s", strings.Join(validOutputFormats, ","))
+	}
+}
+
+// renderYAML writes v as YAML
+// v is round-tripped through JSON first so that the keys match the JSON output (the go-circleci
+// structs only carry json tags)
+func renderYAML(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var generic interface{}
+	if err := yaml.Unmarshal(b, &generic); err != nil {
+		return err
+	}
+
+	b, err = yaml.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// renderTSV writes v as tab separated values with a header row of JSON field names
+// A struct is rendered as a single row and a slice as one row per element. Nested values (maps,
+// slices and structs) are rendered as compact JSON within their column.
+func renderTSV(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+
+	var (
+		rows     []reflect.Value
+		elemType = rv.Type()
+	)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, rv.Index(i))
+		}
+		elemType = elemType.Elem()
+	} else {
+		rows = append(rows, rv)
+	}
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+
+	if elemType.Kind() != reflect.Struct {
+		for _, row := range rows {
+			cell, err := tsvCell(row)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w, cell); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	columns := tsvColumns(elemType)
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.name
+	}
+	if _, err := fmt.Fprintln(w, strings.Join(headers, "\t")); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for row.Kind() == reflect.Ptr || row.Kind() == reflect.Interface {
+			row = row.Elem()
+		}
+
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			if !row.IsValid() {
+				continue
+			}
+
+			cell, err := tsvCell(row.Field(column.index))
+			if err != nil {
+				return err
+			}
+			cells[i] = cell
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(cells, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type tsvColumn struct {
+	name  string
+	index int
+}
+
+// tsvColumns returns the exported fields of t named as they are in the JSON output
+func tsvColumns(t reflect.Type) []tsvColumn {
+	columns := []tsvColumn{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		columns = append(columns, tsvColumn{name: name, index: i})
+	}
+
+	return columns
+}
+
+// tsvCell formats a single value for a TSV column
+func tsvCell(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format(time.RFC3339), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return tsvEscaper.Replace(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Map, reflect.Slice:
+		if v.Len() == 0 {
+			return "", nil
+		}
+	}
+
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return tsvEscaper.Replace(string(b)), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/jszwedko/go-circleci"
+)
+
+// outputBuffer is the number of outputs of each action which are buffered ahead of them being
+// printed, bounding memory use however large the output is
+const outputBuffer = 64
+
+// outputFetcher fetches the output of actions concurrently ahead of them being printed
+type outputFetcher struct {
+	pending map[*circleci.Action]*pendingOutput
+}
+
+// pendingOutput is the output of an action as it is fetched
+// err is set before outputs is closed
+type pendingOutput struct {
+	outputs chan *circleci.Output
+	err     error
+}
+
+// newOutputFetcher starts fetching the output of the actions of the build using up to concurrency
+// requests at once
+// Actions are fetched in the given order so that the first to be printed are ready first
+func newOutputFetcher(build *circleci.Build, actions []*circleci.Action, concurrency int) *outputFetcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	f := &outputFetcher{
+		pending: map[*circleci.Action]*pendingOutput{},
+	}
+	for _, action := range actions {
+		f.pending[action] = &pendingOutput{outputs: make(chan *circleci.Output, outputBuffer)}
+	}
+
+	jobs := make(chan *circleci.Action)
+	go func() {
+		for _, action := range actions {
+			jobs <- action
+		}
+		close(jobs)
+	}()
+
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for action := range jobs {
+				p := f.pending[action]
+				p.err = streamActionOutputs(build, action, func(output *circleci.Output) error {
+					p.outputs <- output
+					return nil
+				})
+				close(p.outputs)
+			}
+		}()
+	}
+
+	return f
+}
+
+// Outputs calls fn with each output of the action as it is fetched, returning once all of it has
+// been
+// Does nothing if the action was not passed to newOutputFetcher
+func (f *outputFetcher) Outputs(action *circleci.Action, fn func(*circleci.Output)) error {
+	p, ok := f.pending[action]
+	if !ok {
+		return nil
+	}
+
+	for output := range p.outputs {
+		fn(output)
+	}
+	return p.err
+}
+
+// nodeActions returns the actions with output of the given nodes of the build, in the order they
+// are printed
+// Actions of non-parallel steps are shared by all nodes so are only included once
+func nodeActions(build *circleci.Build, nodes []int) []*circleci.Action {
+	var (
+		actions = []*circleci.Action{}
+		seen    = map[*circleci.Action]bool{}
+	)
+	for _, node := range nodes {
+		for _, step := range build.Steps {
+			action := stepAction(step, node)
+			if action == nil || !action.HasOutput || seen[action] {
+				continue
+			}
+			seen[action] = true
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
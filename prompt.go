@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/mattn/go-isatty"
+)
+
+// yesFlag is shared by the commands that ask for confirmation before making destructive changes
+var yesFlag = cli.BoolFlag{
+	Name:  "yes, y",
+	Usage: "Do not ask for confirmation",
+}
+
+// stdinReader is shared by everything reading from stdin so that input buffered by one read is not
+// lost to the next
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirm asks the question on stderr and returns whether the user answered yes
+// If --yes was given, the question is not asked
+// As the answer must come from the user, this fails if stdin is not a terminal
+func confirm(c *cli.Context, question string) (bool, error) {
+	if c.Bool("yes") {
+		return true, nil
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false, fmt.Errorf("unable to ask for confirmation as stdin is not a terminal (use --yes)")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// fromFileFlag is shared by the commands that read a secret, so that it need not be passed as an
+// argument where it would be visible in shell history and to other users via ps
+var fromFileFlag = cli.StringFlag{
+	Name:  "from-file",
+	Usage: "Read the value from the file (- for stdin) rather than prompting for it",
+}
+
+// readSecret reads a secret from the file given with --from-file, from stdin if it is not a
+// terminal, or otherwise by prompting for it without echoing the input
+// If multiline is true, the prompt reads until end of input (Ctrl-D) rather than the first line
+// A single trailing newline is removed
+func readSecret(c *cli.Context, prompt string, multiline bool) (string, error) {
+	var (
+		value []byte
+		err   error
+	)
+	switch path := c.String("from-file"); {
+	case path == "-" || (path == "" && !isatty.IsTerminal(os.Stdin.Fd())):
+		value, err = ioutil.ReadAll(stdinReader)
+	case path != "":
+		value, err = ioutil.ReadFile(path)
+	default:
+		var s string
+		s, err = readHidden(prompt, multiline)
+		value = []byte(s)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimSuffix(strings.TrimSuffix(string(value), "\n"), "\r")
+	if secret == "" {
+		return "", fmt.Errorf("no value was given")
+	}
+	return secret, nil
+}
+
+// readHidden prompts for input on stderr and reads it from the terminal without echoing it
+func readHidden(prompt string, multiline bool) (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", fmt.Errorf("unable to read input without echoing it on Windows, use --from-file or stdin instead")
+	}
+
+	state, err := stty("-g")
+	if err != nil {
+		return "", fmt.Errorf("unable to disable echo: %s", err)
+	}
+	if _, err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("unable to disable echo: %s", err)
+	}
+	defer stty(strings.TrimSpace(state))
+
+	fmt.Fprint(os.Stderr, prompt)
+	// the newline typed by the user is not echoed either
+	defer fmt.Fprintln(os.Stderr)
+
+	type result struct {
+		input string
+		err   error
+	}
+	read := make(chan result, 1)
+	go func() {
+		if multiline {
+			b, err := ioutil.ReadAll(stdinReader)
+			read <- result{string(b), err}
+			return
+		}
+
+		line, err := stdinReader.ReadString('\n')
+		if err != nil && line != "" {
+			// a final line without a newline
+			err = nil
+		}
+		read <- result{line, err}
+	}()
+
+	// echo must be restored if interrupted, so wait for the context rather than just the input
+	select {
+	case r := <-read:
+		return r.input, r.err
+	case <-Context.Done():
+		return "", Context.Err()
+	}
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
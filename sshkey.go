@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// parsePrivateKey parses a PEM encoded private key as accepted by CircleCI, returning the MD5
+// fingerprint of its public key as shown by CircleCI (and ssh-keygen -l -E md5)
+// Keys in the OpenSSH format and passphrase protected keys are rejected as CircleCI cannot use them
+func parsePrivateKey(privateKey string) (string, error) {
+	block, rest := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", fmt.Errorf("private key is not PEM encoded")
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return "", fmt.Errorf("expected a single private key")
+	}
+
+	if x509.IsEncryptedPEMBlock(block) || block.Type == "ENCRYPTED PRIVATE KEY" {
+		return "", fmt.Errorf("private key is protected by a passphrase, which CircleCI does not support")
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "OPENSSH PRIVATE KEY":
+		return "", fmt.Errorf("private key is in the OpenSSH format, which CircleCI does not support; convert it to PEM with ssh-keygen -p -m PEM -f <file>")
+	default:
+		return "", fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to parse private key: %s", err)
+	}
+
+	publicKey, err := sshPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := md5.Sum(publicKey)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":"), nil
+}
+
+// sshPublicKey encodes the public key of the private key in the SSH wire format (RFC 4253)
+func sshPublicKey(key interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		writeSSHString(&buf, []byte("ssh-rsa"))
+		writeSSHMPInt(&buf, big.NewInt(int64(key.E)))
+		writeSSHMPInt(&buf, key.N)
+	case *ecdsa.PrivateKey:
+		var curve string
+		switch key.Curve {
+		case elliptic.P256():
+			curve = "nistp256"
+		case elliptic.P384():
+			curve = "nistp384"
+		case elliptic.P521():
+			curve = "nistp521"
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %s", key.Curve.Params().Name)
+		}
+		writeSSHString(&buf, []byte("ecdsa-sha2-"+curve))
+		writeSSHString(&buf, []byte(curve))
+		writeSSHString(&buf, elliptic.Marshal(key.Curve, key.X, key.Y))
+	case ed25519.PrivateKey:
+		writeSSHString(&buf, []byte("ssh-ed25519"))
+		writeSSHString(&buf, key.Public().(ed25519.PublicKey))
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeSSHString(buf *bytes.Buffer, s []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s)))
+	buf.Write(s)
+}
+
+// writeSSHMPInt writes a non-negative integer, with a leading zero byte if its high bit is set so
+// that it is not read as negative
+func writeSSHMPInt(buf *bytes.Buffer, n *big.Int) {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	writeSSHString(buf, b)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+// buildStats summarizes the outcomes, durations and queue times of a set of builds
+type buildStats struct {
+	Key string `json:"key"`
+
+	Builds   int     `json:"builds"`
+	Passed   int     `json:"passed"`
+	Failed   int     `json:"failed"`
+	PassRate float64 `json:"pass_rate"`
+
+	MeanDurationMillis int64 `json:"mean_duration_millis"`
+	P50DurationMillis  int64 `json:"p50_duration_millis"`
+	P95DurationMillis  int64 `json:"p95_duration_millis"`
+
+	MeanQueuedMillis int64 `json:"mean_queued_millis"`
+	P50QueuedMillis  int64 `json:"p50_queued_millis"`
+	P95QueuedMillis  int64 `json:"p95_queued_millis"`
+}
+
+// statsReport summarizes builds overall and broken down by branch and by day
+type statsReport struct {
+	Overall  *buildStats   `json:"overall"`
+	Branches []*buildStats `json:"branches"`
+	Days     []*buildStats `json:"days"`
+}
+
+// computeStats summarizes the finished builds
+func computeStats(builds []*circleci.Build) *statsReport {
+	var (
+		byBranch = map[string][]*circleci.Build{}
+		byDay    = map[string][]*circleci.Build{}
+		finished = []*circleci.Build{}
+	)
+
+	for _, build := range builds {
+		if build.Outcome == "" {
+			continue
+		}
+		finished = append(finished, build)
+
+		byBranch[build.Branch] = append(byBranch[build.Branch], build)
+
+		if start := buildStartTime(build); start != nil {
+			day := start.Local().Format("2006-01-02")
+			byDay[day] = append(byDay[day], build)
+		}
+	}
+
+	report := &statsReport{Overall: summarizeBuilds("all", finished)}
+	for _, branch := range sortedBuildKeys(byBranch) {
+		report.Branches = append(report.Branches, summarizeBuilds(branch, byBranch[branch]))
+	}
+	for _, day := range sortedBuildKeys(byDay) {
+		report.Days = append(report.Days, summarizeBuilds(day, byDay[day]))
+	}
+
+	return report
+}
+
+func summarizeBuilds(key string, builds []*circleci.Build) *buildStats {
+	var (
+		stats     = &buildStats{Key: key, Builds: len(builds)}
+		durations = []time.Duration{}
+		queued    = []time.Duration{}
+	)
+
+	for _, build := range builds {
+		switch build.Outcome {
+		case "success", "fixed", "no_tests":
+			stats.Passed++
+		case "failed", "timedout", "infrastructure_fail":
+			stats.Failed++
+		}
+
+		if build.BuildTimeMillis != nil {
+			durations = append(durations, time.Duration(*build.BuildTimeMillis)*time.Millisecond)
+		}
+
+		if wait, ok := queueTime(build); ok {
+			queued = append(queued, wait)
+		}
+	}
+
+	if stats.Passed+stats.Failed > 0 {
+		stats.PassRate = float64(stats.Passed) / float64(stats.Passed+stats.Failed)
+	}
+
+	stats.MeanDurationMillis, stats.P50DurationMillis, stats.P95DurationMillis = durationStats(durations)
+	stats.MeanQueuedMillis, stats.P50QueuedMillis, stats.P95QueuedMillis = durationStats(queued)
+
+	return stats
+}
+
+// queueTime returns how long the build waited between being queued and starting
+func queueTime(build *circleci.Build) (time.Duration, bool) {
+	if build.QueuedAt == "" || build.StartTime == nil {
+		return 0, false
+	}
+
+	queuedAt, err := time.Parse(time.RFC3339, build.QueuedAt)
+	if err != nil {
+		return 0, false
+	}
+
+	wait := build.StartTime.Sub(queuedAt)
+	if wait < 0 {
+		return 0, false
+	}
+	return wait, true
+}
+
+func buildStartTime(build *circleci.Build) *time.Time {
+	if build.StartTime != nil {
+		return build.StartTime
+	}
+	if queuedAt, err := time.Parse(time.RFC3339, build.QueuedAt); err == nil {
+		return &queuedAt
+	}
+	return nil
+}
+
+// durationStats returns the mean, median and 95th percentile of the durations in milliseconds
+func durationStats(durations []time.Duration) (mean, p50, p95 int64) {
+	if len(durations) == 0 {
+		return 0, 0, 0
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Sort(durationSlice(sorted))
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	return millis(total / time.Duration(len(sorted))), millis(percentile(sorted, 50)), millis(percentile(sorted, 95))
+}
+
+// percentile returns the pth percentile of the sorted durations using the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func printStats(w io.Writer, report *statsReport) {
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	printStatsHeader(t, "")
+	printStatsRow(t, report.Overall)
+
+	fmt.Fprintln(t)
+	printStatsHeader(t, "Branch")
+	for _, stats := range report.Branches {
+		printStatsRow(t, stats)
+	}
+
+	fmt.Fprintln(t)
+	printStatsHeader(t, "Day")
+	for _, stats := range report.Days {
+		printStatsRow(t, stats)
+	}
+
+	t.Flush()
+}
+
+func printStatsHeader(w io.Writer, key string) {
+	fmt.Fprintf(w, "%s\tBuilds\tPassed\tFailed\tPass Rate\tMean\tP50\tP95\tQueued Mean\tQueued P50\tQueued P95\n", key)
+}
+
+func printStatsRow(w io.Writer, stats *buildStats) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f%%\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		stats.Key,
+		stats.Builds,
+		stats.Passed,
+		stats.Failed,
+		stats.PassRate*100,
+		formatMillis(stats.MeanDurationMillis),
+		formatMillis(stats.P50DurationMillis),
+		formatMillis(stats.P95DurationMillis),
+		formatMillis(stats.MeanQueuedMillis),
+		formatMillis(stats.P50QueuedMillis),
+		formatMillis(stats.P95QueuedMillis),
+	)
+}
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func formatMillis(ms int64) time.Duration {
+	return (time.Duration(ms) * time.Millisecond).Round(time.Second)
+}
+
+func sortedBuildKeys(m map[string][]*circleci.Build) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type durationSlice []time.Duration
+
+func (d durationSlice) Len() int           { return len(d) }
+func (d durationSlice) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durationSlice) Less(i, j int) bool { return d[i] < d[j] }
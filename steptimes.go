@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+// stepTiming compares the duration of a step in recent builds against older builds
+type stepTiming struct {
+	Name string `json:"name"`
+
+	BaselineBuilds       int   `json:"baseline_builds"`
+	BaselineMedianMillis int64 `json:"baseline_median_millis"`
+	RecentBuilds         int   `json:"recent_builds"`
+	RecentMedianMillis   int64 `json:"recent_median_millis"`
+
+	Regressed bool `json:"regressed"`
+	// IntroducedBy is the build from which on the step was consistently slower, or 0 if the newest
+	// build was not slower
+	IntroducedBy int `json:"introduced_by,omitempty"`
+}
+
+// stepDuration is the duration of a step in a given build
+type stepDuration struct {
+	buildNum int
+	duration time.Duration
+}
+
+// fetchBuildDetails fetches the full details of each of the builds (including their steps) using
+// the given number of concurrent requests
+// Builds which could not be fetched are reported on errs and left out
+func fetchBuildDetails(builds []*circleci.Build, concurrency int, errs io.Writer) []*circleci.Build {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		details = make([]*circleci.Build, len(builds))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if Context.Err() != nil {
+					// interrupted, skip the remaining builds
+					continue
+				}
+
+				build, err := getBuild(builds[i].Username, builds[i].Reponame, builds[i].BuildNum)
+				if err != nil && Context.Err() != nil {
+					continue
+				}
+				if err != nil {
+					mu.Lock()
+					fmt.Fprintf(errs, "unable to fetch build %d: %s\n", builds[i].BuildNum, err)
+					mu.Unlock()
+					continue
+				}
+				details[i] = build
+			}
+		}()
+	}
+
+	for i := range builds {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	fetched := []*circleci.Build{}
+	for _, build := range details {
+		if build != nil {
+			fetched = append(fetched, build)
+		}
+	}
+	return fetched
+}
+
+// compareStepTimes compares the median duration of each step in the most recent builds against
+// the median in the older builds
+// A step is considered to have regressed if its recent median is more than factor times the
+// baseline median and at least minIncrease longer
+func compareStepTimes(builds []*circleci.Build, recent int, factor float64, minIncrease time.Duration) []*stepTiming {
+	sorted := make([]*circleci.Build, len(builds))
+	copy(sorted, builds)
+	sort.Sort(buildsByBuildNum(sorted))
+
+	durations := map[string][]stepDuration{}
+	for _, build := range sorted {
+		for name, duration := range stepDurations(build) {
+			durations[name] = append(durations[name], stepDuration{buildNum: build.BuildNum, duration: duration})
+		}
+	}
+
+	timings := []*stepTiming{}
+	for name, series := range durations {
+		split := len(series) - recent
+		if split < 1 {
+			// not enough builds to compare against
+			continue
+		}
+
+		baseline, latest := series[:split], series[split:]
+		baselineMedian, recentMedian := medianDuration(baseline), medianDuration(latest)
+		timing := &stepTiming{
+			Name:                 name,
+			BaselineBuilds:       len(baseline),
+			BaselineMedianMillis: millis(baselineMedian),
+			RecentBuilds:         len(latest),
+			RecentMedianMillis:   millis(recentMedian),
+		}
+
+		if float64(recentMedian) > factor*float64(baselineMedian) && recentMedian-baselineMedian >= minIncrease {
+			timing.Regressed = true
+			timing.IntroducedBy, _ = changePoint(series, (baselineMedian+recentMedian)/2)
+		}
+
+		timings = append(timings, timing)
+	}
+
+	sort.Sort(stepTimingsByRegression(timings))
+
+	return timings
+}
+
+// stepDurations returns the duration of each step of the build
+// For parallel steps, the duration of the slowest node is used
+func stepDurations(build *circleci.Build) map[string]time.Duration {
+	durations := map[string]time.Duration{}
+	for _, step := range build.Steps {
+		var longest time.Duration
+		for _, action := range step.Actions {
+			if d := time.Duration(action.RunTimeMillis) * time.Millisecond; d > longest {
+				longest = d
+			}
+		}
+		durations[step.Name] += longest
+	}
+	return durations
+}
+
+// changePoint returns the build after which the step was consistently above the threshold
+// ok is false if the step is not above the threshold in the newest build, as it has since recovered
+func changePoint(series []stepDuration, threshold time.Duration) (buildNum int, ok bool) {
+	if len(series) == 0 || series[len(series)-1].duration <= threshold {
+		return 0, false
+	}
+	for i := len(series) - 2; i >= 0; i-- {
+		if series[i].duration <= threshold {
+			return series[i+1].buildNum, true
+		}
+	}
+	return series[0].buildNum, true
+}
+
+func medianDuration(series []stepDuration) time.Duration {
+	durations := make([]time.Duration, len(series))
+	for i, d := range series {
+		durations[i] = d.duration
+	}
+	sort.Sort(durationSlice(durations))
+	return percentile(durations, 50)
+}
+
+func printStepTimes(w io.Writer, timings []*stepTiming) {
+	regressions := 0
+	for _, timing := range timings {
+		if timing.Regressed {
+			fmt.Fprintf(w, "%q went from %s to %s", timing.Name, formatMillis(timing.BaselineMedianMillis), formatMillis(timing.RecentMedianMillis))
+			if timing.IntroducedBy > 0 {
+				fmt.Fprintf(w, " since build %d", timing.IntroducedBy)
+			}
+			fmt.Fprintln(w)
+			regressions++
+		}
+	}
+	if regressions > 0 {
+		fmt.Fprintln(w)
+	}
+
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(t, "Step\tBaseline Median\tRecent Median\tChange\n")
+	for _, timing := range timings {
+		sprintf := noneSprintf
+		if timing.Regressed {
+			sprintf = failureSprintf
+		}
+
+		change := "n/a"
+		if timing.BaselineMedianMillis > 0 {
+			change = fmt.Sprintf("%+.0f%%", (float64(timing.RecentMedianMillis)/float64(timing.BaselineMedianMillis)-1)*100)
+		}
+
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\n",
+			sprintf("%s", timing.Name),
+			formatMillis(timing.BaselineMedianMillis),
+			formatMillis(timing.RecentMedianMillis),
+			sprintf("%s", change),
+		)
+	}
+	t.Flush()
+}
+
+type buildsByBuildNum []*circleci.Build
+
+func (b buildsByBuildNum) Len() int           { return len(b) }
+func (b buildsByBuildNum) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b buildsByBuildNum) Less(i, j int) bool { return b[i].BuildNum < b[j].BuildNum }
+
+type stepTimingsByRegression []*stepTiming
+
+func (s stepTimingsByRegression) Len() int      { return len(s) }
+func (s stepTimingsByRegression) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s stepTimingsByRegression) Less(i, j int) bool {
+	if s[i].Regressed != s[j].Regressed {
+		return s[i].Regressed
+	}
+	return s[i].RecentMedianMillis-s[i].BaselineMedianMillis > s[j].RecentMedianMillis-s[j].BaselineMedianMillis
+}
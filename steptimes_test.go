@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangePoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      int
+		wantOK    bool
+	}{
+		{"regressed", []time.Duration{10, 10, 10, 30, 30}, 4, true},
+		{"regressed throughout", []time.Duration{30, 30, 30}, 1, true},
+		{"intermittently slow", []time.Duration{10, 30, 10, 30, 30}, 4, true},
+		{"recovered", []time.Duration{10, 10, 30, 30, 10}, 0, false},
+		{"newest at threshold", []time.Duration{10, 30, 30, 20}, 0, false},
+		{"empty", nil, 0, false},
+	}
+
+	for _, test := range tests {
+		series := []stepDuration{}
+		for i, d := range test.durations {
+			series = append(series, stepDuration{buildNum: i + 1, duration: d})
+		}
+
+		got, ok := changePoint(series, 20)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%s: changePoint returned %d, %t, want %d, %t", test.name, got, ok, test.want, test.wantOK)
+		}
+	}
+}
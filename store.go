@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codegangsta/cli"
+	"github.com/jszwedko/go-circleci"
+)
+
+// Store is the local build history store used instead of CircleCI when --offline is given
+var Store *buildStore
+
+// buildStore mirrors builds, test metadata and step output on disk
+//
+// The layout is:
+//
+//	<root>/<host>/<account>/<repo>/builds/<build num>.json
+//	<root>/<host>/<account>/<repo>/tests/<build num>.json
+//	<root>/<host>/<account>/<repo>/output/<build num>/<step>-<node>.json
+//	<root>/<host>/<account>/<repo>/synced.json
+type buildStore struct {
+	root string
+}
+
+func newBuildStore(root, host string) *buildStore {
+	return &buildStore{root: filepath.Join(root, sanitizePathComponent(host))}
+}
+
+// openBuildStore opens the store for the CircleCI host of baseURL in the user's cache directory
+func openBuildStore(baseURL *url.URL) (*buildStore, error) {
+	dir, err := defaultStoreDir()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine build store location: %s", err)
+	}
+	return newBuildStore(dir, baseURL.Host), nil
+}
+
+// defaultStoreDir returns the directory builds are stored in under the user's cache directory
+func defaultStoreDir() (string, error) {
+	dir, err := userCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "circleci-cli"), nil
+}
+
+// userCacheDir returns the platform specific directory for user cache files
+func userCacheDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("%%LocalAppData%% is not set")
+	case "darwin":
+		home, err := homeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Caches"), nil
+	default:
+		if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+			return dir, nil
+		}
+		home, err := homeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".cache"), nil
+	}
+}
+
+func homeDir() (string, error) {
+	if home := os.Getenv("HOME"); home != "" {
+		return home, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine home directory: %s", err)
+	}
+	return u.HomeDir, nil
+}
+
+func sanitizePathComponent(s string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(s)
+}
+
+func (s *buildStore) projectDir(account, repo string) string {
+	return filepath.Join(s.root, sanitizePathComponent(account), sanitizePathComponent(repo))
+}
+
+func (s *buildStore) buildPath(account, repo string, buildNum int) string {
+	return filepath.Join(s.projectDir(account, repo), "builds", fmt.Sprintf("%d.json", buildNum))
+}
+
+func (s *buildStore) testMetadataPath(account, repo string, buildNum int) string {
+	return filepath.Join(s.projectDir(account, repo), "tests", fmt.Sprintf("%d.json", buildNum))
+}
+
+func (s *buildStore) actionOutputsPath(account, repo string, buildNum, step, node int) string {
+	return filepath.Join(s.projectDir(account, repo), "output", strconv.Itoa(buildNum), fmt.Sprintf("%d-%d.json", step, node))
+}
+
+func (s *buildStore) syncedPath(account, repo string) string {
+	return filepath.Join(s.projectDir(account, repo), "synced.json")
+}
+
+// syncState records how far the builds of a project have been synced
+type syncState struct {
+	// SyncedThrough is the build number up to which every build has been stored
+	SyncedThrough int `json:"synced_through"`
+}
+
+// SaveSyncedThrough records that every build of the project up to buildNum has been stored
+func (s *buildStore) SaveSyncedThrough(account, repo string, buildNum int) error {
+	return writeJSONFile(s.syncedPath(account, repo), &syncState{SyncedThrough: buildNum})
+}
+
+// SyncedThrough returns the build number up to which every build of the project has been stored
+// ok is false if the project has not been synced before
+func (s *buildStore) SyncedThrough(account, repo string) (buildNum int, ok bool, err error) {
+	state := &syncState{}
+	if err := readJSONFile(s.syncedPath(account, repo), state); err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return state.SyncedThrough, true, nil
+}
+
+// SaveBuild stores the build, replacing any previously stored version
+func (s *buildStore) SaveBuild(build *circleci.Build) error {
+	return writeJSONFile(s.buildPath(build.Username, build.Reponame, build.BuildNum), build)
+}
+
+// Build retrieves a stored build
+func (s *buildStore) Build(account, repo string, buildNum int) (*circleci.Build, error) {
+	build := &circleci.Build{}
+	if err := readJSONFile(s.buildPath(account, repo, buildNum), build); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("build %d of %s/%s has not been synced", buildNum, account, repo)
+		}
+		return nil, err
+	}
+	return build, nil
+}
+
+// Builds retrieves all of the stored builds of the project, most recent first
+func (s *buildStore) Builds(account, repo string) ([]*circleci.Build, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.projectDir(account, repo), "builds"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*circleci.Build{}, nil
+		}
+		return nil, err
+	}
+
+	builds := []*circleci.Build{}
+	for _, entry := range entries {
+		buildNum, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		build, err := s.Build(account, repo, buildNum)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+
+	sort.Sort(sort.Reverse(buildsByBuildNum(builds)))
+
+	return builds, nil
+}
+
+// SaveTestMetadata stores the test metadata of the build
+func (s *buildStore) SaveTestMetadata(account, repo string, buildNum int, metadata []*circleci.TestMetadata) error {
+	return writeJSONFile(s.testMetadataPath(account, repo, buildNum), metadata)
+}
+
+// TestMetadata retrieves the stored test metadata of the build
+func (s *buildStore) TestMetadata(account, repo string, buildNum int) ([]*circleci.TestMetadata, error) {
+	metadata := []*circleci.TestMetadata{}
+	if err := readJSONFile(s.testMetadataPath(account, repo, buildNum), &metadata); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("test metadata for build %d of %s/%s has not been synced (see sync --tests)", buildNum, account, repo)
+		}
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// HasTestMetadata reports whether the test metadata of the build is stored
+func (s *buildStore) HasTestMetadata(account, repo string, buildNum int) bool {
+	_, err := os.Stat(s.testMetadataPath(account, repo, buildNum))
+	return err == nil
+}
+
+// SaveActionOutputs stores the output of the action at the given step and node of the build as it
+// is streamed by stream, which calls its argument with each output
+// Outputs are written as they arrive so that large output is never held in memory
+func (s *buildStore) SaveActionOutputs(account, repo string, buildNum, step, node int, stream func(func(*circleci.Output) error) error) error {
+	path := s.actionOutputsPath(account, repo, buildNum, step, node)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// written via a temporary file so that readers never see partial output
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	var (
+		w     = bufio.NewWriter(f)
+		enc   = json.NewEncoder(w)
+		first = true
+	)
+	w.WriteString("[")
+	err = stream(func(output *circleci.Output) error {
+		if !first {
+			w.WriteString(",")
+		}
+		first = false
+		return enc.Encode(output)
+	})
+	if err == nil {
+		w.WriteString("]")
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ActionOutputs calls fn with each stored output of the action at the given step and node of the
+// build, decoding them one at a time so that large output is never held in memory
+func (s *buildStore) ActionOutputs(account, repo string, buildNum, step, node int, fn func(*circleci.Output) error) error {
+	f, err := os.Open(s.actionOutputsPath(account, repo, buildNum, step, node))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("output for build %d of %s/%s has not been synced (see sync --outputs)", buildNum, account, repo)
+		}
+		return err
+	}
+	defer f.Close()
+
+	return circleci.DecodeActionOutputs(bufio.NewReader(f), fn)
+}
+
+// HasActionOutputs reports whether the output of the action at the given step and node of the
+// build is stored
+func (s *buildStore) HasActionOutputs(account, repo string, buildNum, step, node int) bool {
+	_, err := os.Stat(s.actionOutputsPath(account, repo, buildNum, step, node))
+	return err == nil
+}
+
+// writeJSONFile writes v to path, via a temporary file so that readers never see partial files
+func writeJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
+
+// listBuilds lists the recent builds of the project from the store, if --offline was given, or
+// from CircleCI
+func listBuilds(account, repo, branch, filter string, limit, offset int) ([]*circleci.Build, error) {
+	if Store == nil {
+		return Client.ListRecentBuildsForProjectContext(Context, account, repo, branch, filter, limit, offset)
+	}
+
+	stored, err := Store.Builds(account, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := []*circleci.Build{}
+	for _, build := range stored {
+		if (branch == "" || build.Branch == branch) && matchesFilter(build, filter) {
+			builds = append(builds, build)
+		}
+	}
+
+	if offset >= len(builds) {
+		return []*circleci.Build{}, nil
+	}
+	builds = builds[offset:]
+	if limit >= 0 && limit < len(builds) {
+		builds = builds[:limit]
+	}
+
+	return builds, nil
+}
+
+// matchesFilter reports whether the build matches the given filter as CircleCI would apply it
+func matchesFilter(build *circleci.Build, filter string) bool {
+	switch filter {
+	case "completed":
+		return build.Lifecycle == "finished"
+	case "successful":
+		return build.Outcome == "success"
+	case "failed":
+		return build.Outcome == "failed"
+	case "running":
+		return build.Lifecycle == "running"
+	default:
+		return true
+	}
+}
+
+// getBuild fetches the build from the store, if --offline was given, or from CircleCI
+func getBuild(account, repo string, buildNum int) (*circleci.Build, error) {
+	if Store == nil {
+		return Client.GetBuildContext(Context, account, repo, buildNum)
+	}
+	return Store.Build(account, repo, buildNum)
+}
+
+// listTestMetadata fetches the test metadata of the build from the store, if --offline was given,
+// or from CircleCI
+func listTestMetadata(account, repo string, buildNum int) ([]*circleci.TestMetadata, error) {
+	if Store == nil {
+		return Client.ListTestMetadataContext(Context, account, repo, buildNum)
+	}
+	return Store.TestMetadata(account, repo, buildNum)
+}
+
+// online wraps the action of a command which only works against CircleCI so that it fails when
+// --offline is given rather than silently ignoring it
+func online(action func(*cli.Context)) func(*cli.Context) {
+	return func(c *cli.Context) {
+		if Store != nil {
+			fmt.Fprintln(os.Stderr, "this command cannot be used with --offline as it needs CircleCI")
+			os.Exit(1)
+		}
+		action(c)
+	}
+}
+
+// syncOptions control what is mirrored into the store by syncProject
+type syncOptions struct {
+	limit        int
+	testMetadata bool
+	outputs      bool
+	concurrency  int
+}
+
+// syncProject mirrors the builds of the project that have not been stored yet into the store, along
+// with any stored builds that had not yet finished or are missing test metadata or step output
+// requested by opts, e.g. as they were synced without --tests
+// The store records the build up to which every build has been stored, so builds which failed to
+// sync or were skipped when interrupted are fetched again by the next sync even if newer builds were
+// stored
+// Returns the number of builds synced
+func syncProject(store *buildStore, account, repo string, opts syncOptions) (int, error) {
+	stored, err := store.Builds(account, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	syncedThrough, ok, err := store.SyncedThrough(account, repo)
+	if err != nil {
+		return 0, err
+	}
+	if !ok && len(stored) > 0 {
+		// synced before the store recorded its progress, check for gaps above the oldest stored build
+		syncedThrough, ok = stored[len(stored)-1].BuildNum, true
+	}
+
+	var (
+		storedBuilds = map[int]*circleci.Build{}
+		pending      = []*circleci.Build{}
+		queued       = map[int]bool{}
+	)
+	for _, build := range stored {
+		storedBuilds[build.BuildNum] = build
+		if !buildFinished(build) || missingFromStore(store, build, opts) {
+			pending = append(pending, build)
+			queued[build.BuildNum] = true
+		}
+	}
+
+	var newer []*circleci.Build
+	if !ok {
+		// nothing stored yet, only fetch up to the limit
+		newer, err = Client.ListRecentBuildsForProjectContext(Context, account, repo, "", "", opts.limit, 0)
+		if err != nil {
+			return 0, err
+		}
+		if len(newer) > 0 {
+			syncedThrough = newer[len(newer)-1].BuildNum - 1
+		}
+	} else {
+		newer, err = buildsSince(account, repo, syncedThrough)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, build := range newer {
+		// builds stored by a previous sync which did not complete are only fetched again if needed
+		if _, ok := storedBuilds[build.BuildNum]; !ok && !queued[build.BuildNum] {
+			pending = append(pending, build)
+			queued[build.BuildNum] = true
+		}
+	}
+
+	concurrency := opts.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		errs   []string
+		synced = 0
+		done   = map[int]bool{}
+		jobs   = make(chan *circleci.Build)
+		wg     sync.WaitGroup
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for summary := range jobs {
+				if Context.Err() != nil {
+					// interrupted, skip the remaining builds
+					continue
+				}
+
+				if err := syncBuild(store, account, repo, summary.BuildNum, opts); err != nil {
+					if Context.Err() != nil {
+						continue
+					}
+
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("build %d: %s", summary.BuildNum, err))
+					mu.Unlock()
+					continue
+				}
+
+				mu.Lock()
+				synced++
+				done[summary.BuildNum] = true
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, build := range pending {
+		jobs <- build
+	}
+	close(jobs)
+	wg.Wait()
+
+	// only advance past builds which are stored without a gap below them
+	through := syncedThrough
+	for _, build := range newer {
+		if build.BuildNum > through {
+			through = build.BuildNum
+		}
+	}
+	for _, build := range newer {
+		if queued[build.BuildNum] && !done[build.BuildNum] && build.BuildNum <= through {
+			through = build.BuildNum - 1
+		}
+	}
+	if through > syncedThrough || (!ok && len(newer) > 0) {
+		if err := store.SaveSyncedThrough(account, repo, through); err != nil {
+			return synced, err
+		}
+	}
+
+	if err := Context.Err(); err != nil {
+		return synced, err
+	}
+
+	if len(errs) > 0 {
+		return synced, fmt.Errorf("unable to sync %d builds:\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+
+	return synced, nil
+}
+
+// missingFromStore reports whether test metadata or step output of the stored build requested by
+// opts has not been stored
+func missingFromStore(store *buildStore, build *circleci.Build, opts syncOptions) bool {
+	if opts.testMetadata && !store.HasTestMetadata(build.Username, build.Reponame, build.BuildNum) {
+		return true
+	}
+
+	if opts.outputs {
+		for _, step := range build.Steps {
+			for _, action := range step.Actions {
+				if action.HasOutput && !store.HasActionOutputs(build.Username, build.Reponame, build.BuildNum, action.Step, action.Index) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// buildsSince pages through the recent builds of the project until reaching the given build
+// number and returns the builds newer than it
+func buildsSince(account, repo string, buildNum int) ([]*circleci.Build, error) {
+	const pageSize = 100
+
+	newer := []*circleci.Build{}
+	for offset := 0; ; offset += pageSize {
+		builds, err := Client.ListRecentBuildsForProjectContext(Context, account, repo, "", "", pageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, build := range builds {
+			if build.BuildNum <= buildNum {
+				return newer, nil
+			}
+			newer = append(newer, build)
+		}
+
+		if len(builds) < pageSize {
+			return newer, nil
+		}
+	}
+}
+
+// syncBuild stores the details of the build and, if it has finished, its test metadata and step
+// output as requested
+// Test metadata and step output which are already stored are not fetched again
+func syncBuild(store *buildStore, account, repo string, buildNum int, opts syncOptions) error {
+	build, err := Client.GetBuildContext(Context, account, repo, buildNum)
+	if err != nil {
+		return err
+	}
+
+	if buildFinished(build) && opts.testMetadata && !store.HasTestMetadata(account, repo, buildNum) {
+		metadata, err := Client.ListTestMetadataContext(Context, account, repo, buildNum)
+		if err != nil {
+			return err
+		}
+		if err := store.SaveTestMetadata(account, repo, buildNum, metadata); err != nil {
+			return err
+		}
+	}
+
+	if buildFinished(build) && opts.outputs {
+		for _, step := range build.Steps {
+			for _, action := range step.Actions {
+				if !action.HasOutput || store.HasActionOutputs(account, repo, buildNum, action.Step, action.Index) {
+					continue
+				}
+
+				err := store.SaveActionOutputs(account, repo, buildNum, action.Step, action.Index, func(fn func(*circleci.Output) error) error {
+					return Client.StreamActionOutputsContext(Context, action, fn)
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// the build is saved last so that it is only considered synced once everything else is
+	return store.SaveBuild(build)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jszwedko/go-circleci"
+)
+
+func TestBuildStore_ActionOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "circleci-cli-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newBuildStore(dir, "circleci.com")
+
+	outputs := []*circleci.Output{
+		{Type: "out", Message: "first\n"},
+		{Type: "err", Message: "second <with> \"escapes\"\n"},
+	}
+	err = store.SaveActionOutputs("jszwedko", "foo", 1, 2, 3, func(fn func(*circleci.Output) error) error {
+		for _, output := range outputs {
+			if err := fn(output); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SaveActionOutputs returned error: %v", err)
+	}
+
+	if !store.HasActionOutputs("jszwedko", "foo", 1, 2, 3) {
+		t.Errorf("HasActionOutputs returned false after saving outputs")
+	}
+
+	got := []*circleci.Output{}
+	err = store.ActionOutputs("jszwedko", "foo", 1, 2, 3, func(output *circleci.Output) error {
+		got = append(got, output)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ActionOutputs returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, outputs) {
+		t.Errorf("ActionOutputs returned %+v, want %+v", got, outputs)
+	}
+}
+
+func TestBuildStore_SaveActionOutputs_empty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "circleci-cli-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newBuildStore(dir, "circleci.com")
+
+	err = store.SaveActionOutputs("jszwedko", "foo", 1, 0, 0, func(fn func(*circleci.Output) error) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SaveActionOutputs returned error: %v", err)
+	}
+
+	n := 0
+	err = store.ActionOutputs("jszwedko", "foo", 1, 0, 0, func(output *circleci.Output) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 0 {
+		t.Errorf("ActionOutputs returned %d outputs and error %v, want none", n, err)
+	}
+}
+
+func TestBuildStore_SaveActionOutputs_streamError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "circleci-cli-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newBuildStore(dir, "circleci.com")
+
+	streamErr := errors.New("connection reset")
+	err = store.SaveActionOutputs("jszwedko", "foo", 1, 0, 0, func(fn func(*circleci.Output) error) error {
+		if err := fn(&circleci.Output{Message: "partial"}); err != nil {
+			return err
+		}
+		return streamErr
+	})
+	if err != streamErr {
+		t.Errorf("SaveActionOutputs returned error %v, want %v", err, streamErr)
+	}
+
+	if store.HasActionOutputs("jszwedko", "foo", 1, 0, 0) {
+		t.Errorf("expected partial output not to be stored")
+	}
+
+	err = store.ActionOutputs("jszwedko", "foo", 1, 0, 0, func(output *circleci.Output) error {
+		return nil
+	})
+	if err == nil {
+		t.Errorf("ActionOutputs returned no error for output which has not been stored")
+	}
+}
+
+func TestSyncProject_backfillsFailedBuilds(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failing  = map[int]bool{3: true}
+		requests = map[string]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path]++
+
+		if r.URL.Path == "/project/jszwedko/foo" {
+			builds := []*circleci.Build{}
+			for buildNum := 5; buildNum >= 1; buildNum-- {
+				builds = append(builds, &circleci.Build{BuildNum: buildNum, Username: "jszwedko", Reponame: "foo", Lifecycle: "finished"})
+			}
+			json.NewEncoder(w).Encode(builds)
+			return
+		}
+
+		var buildNum int
+		if _, err := fmt.Sscanf(r.URL.Path, "/project/jszwedko/foo/%d", &buildNum); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if failing[buildNum] {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(&circleci.Build{BuildNum: buildNum, Username: "jszwedko", Reponame: "foo", Lifecycle: "finished"})
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL + "/")
+	Client = &circleci.Client{BaseURL: baseURL}
+
+	dir, err := ioutil.TempDir("", "circleci-cli-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newBuildStore(dir, "circleci.com")
+	opts := syncOptions{limit: 5, concurrency: 2}
+
+	synced, err := syncProject(store, "jszwedko", "foo", opts)
+	if err == nil || !strings.Contains(err.Error(), "build 3") {
+		t.Errorf("syncProject returned error %v, want failure of build 3", err)
+	}
+	if synced != 4 {
+		t.Errorf("syncProject synced %d builds, want 4", synced)
+	}
+	if through, _, _ := store.SyncedThrough("jszwedko", "foo"); through != 2 {
+		t.Errorf("SyncedThrough returned %d after failure of build 3, want 2", through)
+	}
+
+	mu.Lock()
+	failing = map[int]bool{}
+	requests = map[string]int{}
+	mu.Unlock()
+
+	synced, err = syncProject(store, "jszwedko", "foo", opts)
+	if err != nil {
+		t.Fatalf("syncProject returned error: %v", err)
+	}
+	if synced != 1 {
+		t.Errorf("syncProject synced %d builds, want only the missing build", synced)
+	}
+	if _, err := store.Build("jszwedko", "foo", 3); err != nil {
+		t.Errorf("expected build 3 to be backfilled: %v", err)
+	}
+	if requests["/project/jszwedko/foo/4"] != 0 || requests["/project/jszwedko/foo/5"] != 0 {
+		t.Errorf("expected stored builds not to be fetched again, requests were %v", requests)
+	}
+	if through, _, _ := store.SyncedThrough("jszwedko", "foo"); through != 5 {
+		t.Errorf("SyncedThrough returned %d, want 5", through)
+	}
+}
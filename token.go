@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// runTokenCommand runs a credential helper to retrieve the API token, returning its trimmed output
+func runTokenCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	var stdout, stderr bytes.Buffer
+	// helpers such as pass may need to prompt for a passphrase, but piped input is left for the
+	// command, e.g. a secret read by add-env-var
+	if isatty.IsTerminal(os.Stdin.Fd()) {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("token-command %q failed, %s: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("token-command %q failed, %s", command, err)
+	}
+
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", fmt.Errorf("token-command %q did not print a token", command)
+	}
+
+	return token, nil
+}
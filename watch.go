@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/fatih/color"
+	"github.com/jszwedko/go-circleci"
+	"github.com/mattn/go-isatty"
+)
+
+// Exit codes used when waiting for a build to finish
+// 1 is reserved for errors (e.g. being unable to talk to CircleCI)
+const (
+	exitBuildSuccess            = 0
+	exitBuildFailed             = 2
+	exitBuildTimedout           = 3
+	exitBuildCanceled           = 4
+	exitBuildInfrastructureFail = 5
+	exitBuildUnknown            = 6
+)
+
+// intervalFlag is shared by the commands that wait for a build to finish
+var intervalFlag = cli.DurationFlag{
+	Name:   "interval",
+	Value:  5 * time.Second,
+	Usage:  "How often to poll CircleCI while waiting for a build to finish",
+	EnvVar: "CIRCLE_INTERVAL",
+}
+
+// buildExitCode maps the outcome of a finished build to an exit code
+func buildExitCode(build *circleci.Build) int {
+	outcome := build.Outcome
+	if outcome == "" {
+		outcome = build.Status
+	}
+
+	switch outcome {
+	case "success", "fixed", "no_tests":
+		return exitBuildSuccess
+	case "failed", "failure":
+		return exitBuildFailed
+	case "timedout":
+		return exitBuildTimedout
+	case "canceled":
+		return exitBuildCanceled
+	case "infrastructure_fail":
+		return exitBuildInfrastructureFail
+	default:
+		return exitBuildUnknown
+	}
+}
+
+func buildFinished(build *circleci.Build) bool {
+	return build.Lifecycle == "finished" || build.Lifecycle == "not_run"
+}
+
+// watchBuild polls the given build until it has finished, redrawing the progress of each node as
+// it goes
+// Returns the exit code matching the outcome of the build
+func watchBuild(c *cli.Context, account, repo string, buildNum int) int {
+	var (
+		build    *circleci.Build
+		err      error
+		progress = newProgressWriter(color.Output, isatty.IsTerminal(os.Stdout.Fd()))
+	)
+
+	for {
+		build, err = Client.GetBuildContext(Context, account, repo, buildNum)
+		if err != nil {
+			handleClientError(err)
+		}
+
+		if c.GlobalString("output") == "" {
+			progress.Update(build)
+		}
+
+		if buildFinished(build) {
+			break
+		}
+
+		if err := sleep(c.Duration("interval")); err != nil {
+			handleClientError(err)
+		}
+	}
+
+	printStructured(c, build)
+
+	return buildExitCode(build)
+}
+
+// writeBuildProgress writes the status of each step of each node of the build
+func writeBuildProgress(w io.Writer, build *circleci.Build) {
+	fmt.Fprintln(w, statusSprintfFunc(build.Status)("Build %d (%s)", build.BuildNum, build.Status))
+
+	nodes := buildNodes(build)
+	for i := 0; i < nodes; i++ {
+		if nodes > 1 {
+			fmt.Fprintf(w, "Node %d\n", i)
+		}
+
+		for _, step := range build.Steps {
+			action := stepAction(step, i)
+			if action == nil {
+				continue
+			}
+
+			colorSprintfFunc := statusSprintfFunc(action.Status)
+			fmt.Fprint(w, colorSprintfFunc("* %s (%s)", step.Name, action.Status))
+			switch {
+			case action.StartTime != nil && action.EndTime != nil:
+				fmt.Fprint(w, colorSprintfFunc(" (%s)", action.EndTime.Sub(*action.StartTime)))
+			case action.StartTime != nil:
+				fmt.Fprint(w, colorSprintfFunc(" (%s)", time.Since(*action.StartTime).Round(time.Second)))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// buildNodes returns the number of nodes the build runs on
+func buildNodes(build *circleci.Build) int {
+	if build.Parallel < 1 {
+		return 1
+	}
+	return build.Parallel
+}
+
+// stepAction returns the action of the step that ran on the given node
+// Returns nil if the step has not yet started on that node
+func stepAction(step *circleci.Step, node int) *circleci.Action {
+	if len(step.Actions) == 0 {
+		return nil
+	}
+
+	action := step.Actions[0]
+	if action.Parallel {
+		if node >= len(step.Actions) {
+			return nil
+		}
+		action = step.Actions[node]
+	}
+
+	return action
+}
+
+// progressWriter prints the progress of a build each time it is polled
+// On a terminal, the previous progress is redrawn; otherwise only status changes are printed so
+// that logs stay readable
+type progressWriter struct {
+	w        io.Writer
+	terminal bool
+
+	lines    int
+	statuses map[string]string
+}
+
+func newProgressWriter(w io.Writer, terminal bool) *progressWriter {
+	return &progressWriter{w: w, terminal: terminal, statuses: map[string]string{}}
+}
+
+// Update prints the progress of the build
+func (p *progressWriter) Update(build *circleci.Build) {
+	if p.terminal {
+		buf := &bytes.Buffer{}
+		writeBuildProgress(buf, build)
+
+		if p.lines > 0 {
+			// move the cursor to the start of the previous progress and clear it
+			fmt.Fprintf(p.w, "\x1b[%dA\x1b[J", p.lines)
+		}
+		p.lines = strings.Count(buf.String(), "\n")
+		buf.WriteTo(p.w)
+		return
+	}
+
+	p.printChange("build", build.Status, statusSprintfFunc(build.Status)("Build %d (%s)", build.BuildNum, build.Status))
+	for i := 0; i < buildNodes(build); i++ {
+		for j, step := range build.Steps {
+			action := stepAction(step, i)
+			if action == nil || (i > 0 && !action.Parallel) {
+				continue
+			}
+
+			p.printChange(
+				fmt.Sprintf("%d/%d", i, j),
+				action.Status,
+				statusSprintfFunc(action.Status)("Node %d: %s (%s)", i, step.Name, action.Status),
+			)
+		}
+	}
+}
+
+func (p *progressWriter) printChange(key, status, line string) {
+	if p.statuses[key] == status {
+		return
+	}
+	p.statuses[key] = status
+	fmt.Fprintln(p.w, line)
+}